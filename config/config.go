@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
 var stderr = log.New(os.Stderr, "", 0)
@@ -13,28 +14,100 @@ var stderr = log.New(os.Stderr, "", 0)
 // Filled during build
 var version string
 
+const (
+	defaultListenAddress = ":9567"
+	defaultProbeInterval = time.Minute
+	defaultSamples       = 1
+	defaultPercentile    = 50
+
+	defaultCertWarningDays = 14
+
+	defaultGraphDataSize = "custom 1d, 5m for 1w, 30m for 1t, 1d for 1y"
+)
+
 // Config holds the application configuration
 type Config struct {
-	URIs map[string]string
+	Targets map[string]Target
 
 	RandomDelayEnabled bool
 	ConfigAndPing      bool
 	UserAgent          string
 	Suffix             string
+	MinMaxEnabled      bool
+	OutputFormat       string
+
+	ListenAddress string
+	ProbeInterval time.Duration
+
+	// Read the results cached by the daemon instead of pinging on fetch
+	DaemonEnabled bool
+	StateDir      string
+
+	// Report every sample gathered by the daemon since the last fetch
+	SupersamplingEnabled bool
+	GraphDataSize        string
 }
 
 // NewConfigFromEnv creates and fills a Config from os.Environ()
 func NewConfigFromEnv() Config {
 	var config Config
 
-	config.URIs = getURIsFromEnv(os.Environ())
+	var fileTargets map[string]Target
+	if path := os.Getenv("CONFIG_FILE"); len(path) > 0 {
+		fileTargets = getTargetsFromFile(path)
+	}
+
+	config.Targets = getTargetsFromEnv(os.Environ(), fileTargets)
+	setDefaultThresholds(
+		config.Targets,
+		getThresholdsFromEnv(os.Environ(), "WARN_"),
+		getThresholdsFromEnv(os.Environ(), "CRIT_"),
+	)
 	config.RandomDelayEnabled = os.Getenv("RANDOM_DELAY") == "1"
+	config.MinMaxEnabled = os.Getenv("MIN_MAX") == "1"
+	config.OutputFormat = os.Getenv("OUTPUT_FORMAT")
+	setDefaultSampling(config.Targets, os.Getenv("SAMPLES"), os.Getenv("PERCENTILE"))
+	setDNSServer(config.Targets, os.Getenv("DNS_SERVER"))
 	config.UserAgent = os.Getenv("USER_AGENT")
 
 	if len(config.UserAgent) == 0 {
 		config.UserAgent = fmt.Sprintf("http-timing/%s", version)
 	}
 
+	config.ListenAddress = os.Getenv("LISTEN_ADDRESS")
+	if len(config.ListenAddress) == 0 {
+		config.ListenAddress = defaultListenAddress
+	}
+
+	config.ProbeInterval = defaultProbeInterval
+	if interval := os.Getenv("PROBE_INTERVAL"); len(interval) > 0 {
+		var err error
+		config.ProbeInterval, err = time.ParseDuration(interval)
+		if err != nil || config.ProbeInterval <= 0 {
+			stderr.Printf("Invalid PROBE_INTERVAL: %s\n", interval)
+			config.ProbeInterval = defaultProbeInterval
+		}
+	}
+
+	config.DaemonEnabled = os.Getenv("DAEMON") == "1"
+
+	// http://guide.munin-monitoring.org/en/latest/plugin/supersampling.html
+	config.SupersamplingEnabled = os.Getenv("SUPERSAMPLING") == "1"
+	if config.SupersamplingEnabled && !config.DaemonEnabled {
+		stderr.Print("SUPERSAMPLING requires DAEMON to be enabled, ignoring.\n")
+		config.SupersamplingEnabled = false
+	}
+	config.GraphDataSize = os.Getenv("GRAPH_DATA_SIZE")
+	if len(config.GraphDataSize) == 0 {
+		config.GraphDataSize = defaultGraphDataSize
+	}
+
+	// http://guide.munin-monitoring.org/en/latest/plugin/env.html
+	config.StateDir = os.Getenv("MUNIN_PLUGSTATE")
+	if len(config.StateDir) == 0 {
+		config.StateDir = os.TempDir()
+	}
+
 	// https://munin.readthedocs.io/en/latest/plugin/protocol-dirtyconfig.html#plugin-protocol-dirtyconfig
 	config.ConfigAndPing = os.Getenv("MUNIN_CAP_DIRTYCONFIG") == "1"
 
@@ -60,12 +133,19 @@ func (c Config) GetGraphName() string {
 	return "timing_" + c.Suffix
 }
 
-// getURIsFromEnv returns a map associating names to urls from the process env vars
+// getTargetsFromEnv returns a map associating names to targets from the
+// process env vars
 // Only vars prefixed with 'TARGET_' will be used, eg.
 // TARGET_EXAMPLE=https://example.com/ will register the URI with "example"
 // as the name.
-func getURIsFromEnv(environ []string) map[string]string {
-	uris := make(map[string]string, 0)
+// Options are read from vars suffixed with an option name, eg.
+// TARGET_EXAMPLE_METHOD=HEAD, or TARGET_EXAMPLE_HEADER_ACCEPT=text/html for
+// headers.
+// The given base targets are overridden by the env vars: a TARGET_<NAME>
+// var replaces the URI of the base target of the same name and its
+// TARGET_<NAME>_<OPTION> vars override its options.
+func getTargetsFromEnv(environ []string, base map[string]Target) map[string]Target {
+	vars := make(map[string]string, 0)
 
 	for _, env := range environ {
 		// Filter TARGET_*
@@ -75,21 +155,198 @@ func getURIsFromEnv(environ []string) map[string]string {
 		}
 
 		// Check for values
-		name := strings.ToLower(strings.Split(parts[1], "=")[0])
-		uri := strings.SplitN(env, "=", 2)[1]
-		if len(name) <= 0 || len(uri) <= 0 {
+		kv := strings.SplitN(parts[1], "=", 2)
+		if len(kv) != 2 || len(kv[0]) <= 0 || len(kv[1]) <= 0 {
+			continue
+		}
+
+		vars[strings.ToLower(kv[0])] = kv[1]
+	}
+
+	targets := make(map[string]Target, len(base))
+	names := make(map[string]string, len(vars)+len(base))
+	for name, target := range base {
+		targets[name] = target
+		names[name] = target.URI
+	}
+	for name, value := range vars {
+		names[name] = value
+	}
+
+	options := make(map[string]string, 0)
+	for name, value := range vars {
+		if _, _, _, ok := parseTargetOption(name, names); ok {
+			options[name] = value
 			continue
 		}
 
 		// Check if URI is valid
-		_, err := url.ParseRequestURI(uri)
+		_, err := url.ParseRequestURI(value)
 		if err != nil {
-			stderr.Printf("Invalid URI: %s\n", env)
+			stderr.Printf("Invalid URI: TARGET_%s=%s\n", strings.ToUpper(name), RedactURI(value))
+			continue
+		}
+
+		if target, ok := targets[name]; ok {
+			target.URI = value
+			targets[name] = target
+		} else {
+			targets[name] = NewTarget(value)
+		}
+	}
+
+	for key, value := range options {
+		if err := applyTargetOption(targets, key, value); err != nil {
+			stderr.Printf("Invalid option: TARGET_%s=%s (%s)\n", strings.ToUpper(key), RedactURI(value), err)
+		}
+	}
+
+	return targets
+}
+
+// parseTargetOption splits a lowercased TARGET_ var name into the name of the
+// target it applies to, the option and the option key (eg. the header name).
+// ok is false if the var is not an option of any of the given names.
+func parseTargetOption(key string, names map[string]string) (name, option, optionKey string, ok bool) {
+	for option := range targetOptions {
+		name := strings.TrimSuffix(key, "_"+strings.ToLower(option))
+		if _, exists := names[name]; exists && name != key {
+			return name, option, "", true
+		}
+	}
+
+	for option := range targetPrefixOptions {
+		infix := "_" + strings.ToLower(option) + "_"
+		for i := 0; ; i++ {
+			next := strings.Index(key[i:], infix)
+			if next < 0 {
+				break
+			}
+			i += next
+
+			if _, exists := names[key[:i]]; exists && i > 0 {
+				return key[:i], option, key[i+len(infix):], true
+			}
+		}
+	}
+
+	return "", "", "", false
+}
+
+// applyTargetOption applies the option given by its lowercased TARGET_ var
+// name to the matching target.
+func applyTargetOption(targets map[string]Target, key, value string) error {
+	uris := make(map[string]string, len(targets))
+	for name, target := range targets {
+		uris[name] = target.URI
+	}
+
+	name, option, optionKey, ok := parseTargetOption(key, uris)
+	if !ok {
+		// The option belongs to a target whose URI was invalid
+		return nil
+	}
+
+	target := targets[name]
+	var err error
+	if apply, isPrefix := targetPrefixOptions[option]; isPrefix {
+		err = apply(&target, optionKey, value)
+	} else {
+		err = targetOptions[option](&target, value)
+	}
+	targets[name] = target
+
+	return err
+}
+
+// getThresholdsFromEnv returns the global thresholds indexed by phase from the
+// process env vars prefixed by the given prefix, eg. WARN_TOTAL=500ms
+func getThresholdsFromEnv(environ []string, prefix string) map[string]time.Duration {
+	thresholds := make(map[string]time.Duration, 0)
+
+	for _, env := range environ {
+		if !strings.HasPrefix(env, prefix) {
 			continue
 		}
 
-		uris[name] = uri
+		kv := strings.SplitN(strings.TrimPrefix(env, prefix), "=", 2)
+		if len(kv) != 2 || len(kv[0]) <= 0 || len(kv[1]) <= 0 {
+			continue
+		}
+
+		if err := setThreshold(thresholds, strings.ToLower(kv[0]), kv[1]); err != nil {
+			stderr.Printf("Invalid threshold: %s (%s)\n", env, err)
+		}
+	}
+
+	return thresholds
+}
+
+// setDefaultThresholds applies the global thresholds to the targets that
+// don't define their own for the same phase
+func setDefaultThresholds(targets map[string]Target, warning, critical map[string]time.Duration) {
+	for _, target := range targets {
+		for phase, threshold := range warning {
+			if _, ok := target.Warning[phase]; !ok {
+				target.Warning[phase] = threshold
+			}
+		}
+
+		for phase, threshold := range critical {
+			if _, ok := target.Critical[phase]; !ok {
+				target.Critical[phase] = threshold
+			}
+		}
+	}
+}
+
+// setDNSServer makes all the targets resolve their host using the given DNS
+// server
+func setDNSServer(targets map[string]Target, dnsServerEnv string) {
+	if len(dnsServerEnv) <= 0 {
+		return
+	}
+
+	server, err := parseDNSServer(dnsServerEnv)
+	if err != nil {
+		stderr.Printf("Invalid DNS_SERVER: %s (%s)\n", dnsServerEnv, err)
+		return
+	}
+
+	for name, target := range targets {
+		target.DNSServer = server
+		targets[name] = target
+	}
+}
+
+// setDefaultSampling applies the global number of samples and percentile to
+// the targets that don't define their own
+func setDefaultSampling(targets map[string]Target, samplesEnv, percentileEnv string) {
+	samples, percentile := defaultSamples, float64(defaultPercentile)
+
+	if len(samplesEnv) > 0 {
+		var err error
+		if samples, err = parseSamples(samplesEnv); err != nil {
+			stderr.Printf("Invalid SAMPLES: %s (%s)\n", samplesEnv, err)
+			samples = defaultSamples
+		}
+	}
+
+	if len(percentileEnv) > 0 {
+		var err error
+		if percentile, err = parsePercentile(percentileEnv); err != nil {
+			stderr.Printf("Invalid PERCENTILE: %s (%s)\n", percentileEnv, err)
+			percentile = defaultPercentile
+		}
 	}
 
-	return uris
+	for name, target := range targets {
+		if target.Samples == 0 {
+			target.Samples = samples
+		}
+		if target.Percentile == 0 {
+			target.Percentile = percentile
+		}
+		targets[name] = target
+	}
 }
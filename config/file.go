@@ -0,0 +1,310 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// getTargetsFromFile returns the targets defined in the given JSON file, eg.
+//
+//	{
+//	    "targets": {
+//	        "example": {
+//	            "uri": "https://example.com/",
+//	            "method": "HEAD",
+//	            "header": {"Accept": "text/html"},
+//	            "warn": {"total": "500ms"}
+//	        }
+//	    }
+//	}
+//
+// Target keys are the lowercased TARGET_<NAME>_<OPTION> option names, options
+// taking a key (eg. HEADER) are given as objects.
+// Errors are printed along with the file name and line, invalid targets and
+// options are ignored.
+func getTargetsFromFile(path string) map[string]Target {
+	targets := make(map[string]Target, 0)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		stderr.Printf("Invalid config file: %s\n", err)
+		return targets
+	}
+
+	p := &fileParser{path: path, data: data, decoder: json.NewDecoder(bytes.NewReader(data))}
+	p.decoder.UseNumber()
+	if err := p.parse(targets); err != nil {
+		stderr.Print(err)
+	}
+
+	return targets
+}
+
+// fileOption is a target option read from the config file
+type fileOption struct {
+	offset    int64
+	option    string
+	optionKey string
+	value     string
+}
+
+// fileParser walks a JSON config file token by token to be able to report
+// the line of any invalid value
+type fileParser struct {
+	path    string
+	data    []byte
+	decoder *json.Decoder
+}
+
+// parse reads the whole file, filling the given targets
+// Invalid targets and options are reported to stderr, only syntax errors
+// are returned.
+func (p *fileParser) parse(targets map[string]Target) error {
+	if err := p.expectDelim('{'); err != nil {
+		return err
+	}
+
+	for p.decoder.More() {
+		key, offset, err := p.readKey()
+		if err != nil {
+			return err
+		}
+
+		if key != "targets" {
+			p.report(offset, "unknown key %q", key)
+			if err := p.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.parseTargets(targets); err != nil {
+			return err
+		}
+	}
+
+	return p.expectDelim('}')
+}
+
+func (p *fileParser) parseTargets(targets map[string]Target) error {
+	if err := p.expectDelim('{'); err != nil {
+		return err
+	}
+
+	for p.decoder.More() {
+		name, offset, err := p.readKey()
+		if err != nil {
+			return err
+		}
+
+		target, ok, err := p.parseTarget(offset)
+		if err != nil {
+			return err
+		}
+		if ok {
+			targets[strings.ToLower(name)] = target
+		}
+	}
+
+	return p.expectDelim('}')
+}
+
+// parseTarget reads a single target object, ok is false if the target is
+// invalid and must be ignored
+func (p *fileParser) parseTarget(targetOffset int64) (target Target, ok bool, err error) {
+	if err := p.expectDelim('{'); err != nil {
+		return target, false, err
+	}
+
+	var uri string
+	options := make([]fileOption, 0)
+	for p.decoder.More() {
+		key, offset, err := p.readKey()
+		if err != nil {
+			return target, false, err
+		}
+
+		option := strings.ToUpper(key)
+		switch {
+		case key == "uri":
+			if uri, err = p.readScalar(); err != nil {
+				return target, false, err
+			}
+		case targetOptions[option] != nil:
+			value, err := p.readScalar()
+			if err != nil {
+				return target, false, err
+			}
+			options = append(options, fileOption{offset, option, "", value})
+		case targetPrefixOptions[option] != nil:
+			values, err := p.readObject()
+			if err != nil {
+				return target, false, err
+			}
+			for _, value := range values {
+				value.option = option
+				options = append(options, value)
+			}
+		default:
+			p.report(offset, "unknown target option %q", key)
+			if err := p.skip(); err != nil {
+				return target, false, err
+			}
+		}
+	}
+
+	if err := p.expectDelim('}'); err != nil {
+		return target, false, err
+	}
+
+	if _, err := url.ParseRequestURI(uri); err != nil {
+		p.report(targetOffset, "invalid URI %q", RedactURI(uri))
+		return target, false, nil
+	}
+
+	target = NewTarget(uri)
+	for _, option := range options {
+		var err error
+		if apply, isPrefix := targetPrefixOptions[option.option]; isPrefix {
+			err = apply(&target, strings.ToLower(option.optionKey), option.value)
+		} else {
+			err = targetOptions[option.option](&target, option.value)
+		}
+
+		if err != nil {
+			p.report(option.offset, "invalid %s %q (%s)", strings.ToLower(option.option), RedactURI(option.value), err)
+		}
+	}
+
+	return target, true, nil
+}
+
+// readObject reads an object of scalars
+func (p *fileParser) readObject() ([]fileOption, error) {
+	if err := p.expectDelim('{'); err != nil {
+		return nil, err
+	}
+
+	values := make([]fileOption, 0)
+	for p.decoder.More() {
+		key, offset, err := p.readKey()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := p.readScalar()
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, fileOption{offset: offset, optionKey: key, value: value})
+	}
+
+	return values, p.expectDelim('}')
+}
+
+// readKey reads an object key and returns it along with its offset
+func (p *fileParser) readKey() (string, int64, error) {
+	offset := p.decoder.InputOffset()
+	token, err := p.token()
+	if err != nil {
+		return "", offset, err
+	}
+
+	return token.(string), offset, nil
+}
+
+// readScalar reads a string, number or boolean and returns it as a string
+func (p *fileParser) readScalar() (string, error) {
+	offset := p.decoder.InputOffset()
+	token, err := p.token()
+	if err != nil {
+		return "", err
+	}
+
+	switch value := token.(type) {
+	case string:
+		return value, nil
+	case json.Number, bool:
+		return fmt.Sprint(value), nil
+	}
+
+	return "", p.errorf(offset, "expected a string, number or boolean")
+}
+
+// skip reads and discards the next value
+func (p *fileParser) skip() error {
+	var value json.RawMessage
+	offset := p.decoder.InputOffset()
+	if err := p.decoder.Decode(&value); err != nil {
+		return p.wrap(offset, err)
+	}
+
+	return nil
+}
+
+func (p *fileParser) expectDelim(delim json.Delim) error {
+	offset := p.decoder.InputOffset()
+	token, err := p.token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return p.errorf(offset, "expected %q", delim)
+	}
+
+	return nil
+}
+
+// token returns the next token with errors wrapped with the file and line
+func (p *fileParser) token() (json.Token, error) {
+	offset := p.decoder.InputOffset()
+	token, err := p.decoder.Token()
+	if err != nil {
+		return nil, p.wrap(offset, err)
+	}
+
+	return token, nil
+}
+
+func (p *fileParser) wrap(offset int64, err error) error {
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		offset = syntaxErr.Offset
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return p.errorf(offset, "%s", err)
+}
+
+// report prints a non-fatal error
+func (p *fileParser) report(offset int64, format string, args ...interface{}) {
+	stderr.Print(p.errorf(offset, format, args...))
+}
+
+// errorf returns an error prefixed with the file name and line of the offset
+func (p *fileParser) errorf(offset int64, format string, args ...interface{}) error {
+	line := bytes.Count(p.data[:p.skipSpaces(offset)], []byte("\n")) + 1
+	return fmt.Errorf("Invalid config file: %s:%d: %s\n", p.path, line, fmt.Sprintf(format, args...))
+}
+
+// skipSpaces returns the offset of the first non-blank char after offset, as
+// the decoder offsets point right after the previous token
+func (p *fileParser) skipSpaces(offset int64) int64 {
+	if offset > int64(len(p.data)) {
+		return int64(len(p.data))
+	}
+
+	for offset < int64(len(p.data)) && strings.ContainsRune(" \t\r\n,:", rune(p.data[offset])) {
+		offset++
+	}
+
+	return offset
+}
@@ -0,0 +1,381 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target holds everything needed to ping a single URI
+type Target struct {
+	URI          string
+	Method       string
+	Headers      http.Header
+	Body         string
+	Timeout      time.Duration
+	ExpectStatus int
+
+	// Names of the headers read from files, not sent to other hosts when
+	// following redirections as with the Authorization and Cookie ones
+	SecretHeaders []string
+
+	// Maximum number of redirections to follow, none by default
+	FollowRedirects int
+
+	// Response body assertions, see the README for the JSON path syntax
+	AssertContains  string
+	AssertRegexp    *regexp.Regexp
+	AssertJSONPath  string
+	AssertJSONValue string
+
+	// Days before the certificate expiration from which to warn
+	CertWarningDays int
+
+	// TLS options: PEM files of the CAs to trust instead of the system ones
+	// and of the client certificate and key, server name to verify and send
+	// with SNI instead of the URI host
+	CAFile             string
+	ClientCert         string
+	ClientKey          string
+	ServerName         string
+	InsecureSkipVerify bool
+
+	// Proxy URL to go through instead of the one given by HTTPS_PROXY and the
+	// like
+	Proxy string
+
+	// Protocol to force: http1, h2 or h2c (HTTP/2 without TLS), any when empty
+	Protocol string
+
+	// Number of retries after a failed request and delay before the first
+	// one, doubled after each retry
+	Retries      int
+	RetryBackoff time.Duration
+
+	// IP versions to probe the target over, in order, any when empty
+	IPVersions []int
+
+	// IP address to connect to instead of resolving the URI host, and DNS
+	// server (ip:port) to resolve it with otherwise, the system one if empty
+	Resolve   string
+	DNSServer string
+
+	// Number of sequential requests per run and percentile reported
+	Samples    int
+	Percentile float64
+
+	// Thresholds indexed by phase name, "total" included
+	Warning  map[string]time.Duration
+	Critical map[string]time.Duration
+}
+
+// targetOptions maps the TARGET_<NAME>_<OPTION> suffixes to the function
+// applying their value to the target.
+var targetOptions = map[string]func(t *Target, value string) error{
+	"METHOD": func(t *Target, value string) error {
+		t.Method = strings.ToUpper(value)
+		return nil
+	},
+	"BASIC_AUTH_FILE": func(t *Target, value string) error {
+		credentials, err := readSecretFile(value)
+		if err == nil && !strings.Contains(credentials, ":") {
+			err = fmt.Errorf("expected <user>:<password> in %s", value)
+		}
+		if err != nil {
+			return err
+		}
+
+		t.Headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+		return nil
+	},
+	"BEARER_TOKEN_FILE": func(t *Target, value string) error {
+		token, err := readSecretFile(value)
+		if err != nil {
+			return err
+		}
+
+		t.Headers.Set("Authorization", "Bearer "+token)
+		return nil
+	},
+	"BODY": func(t *Target, value string) error {
+		t.Body = value
+		return nil
+	},
+	"TIMEOUT": func(t *Target, value string) (err error) {
+		t.Timeout, err = time.ParseDuration(value)
+		return
+	},
+	"EXPECT_STATUS": func(t *Target, value string) (err error) {
+		t.ExpectStatus, err = strconv.Atoi(value)
+		return
+	},
+	"FOLLOW_REDIRECTS": func(t *Target, value string) (err error) {
+		t.FollowRedirects, err = strconv.Atoi(value)
+		if err == nil && t.FollowRedirects < 0 {
+			err = fmt.Errorf("cannot follow a negative number of redirections")
+		}
+		return
+	},
+	"ASSERT_CONTAINS": func(t *Target, value string) error {
+		t.AssertContains = value
+		return nil
+	},
+	"ASSERT_REGEXP": func(t *Target, value string) (err error) {
+		t.AssertRegexp, err = regexp.Compile(value)
+		return
+	},
+	"ASSERT_JSON": func(t *Target, value string) error {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || len(kv[0]) <= 0 {
+			return fmt.Errorf("expected <path>=<value>")
+		}
+
+		t.AssertJSONPath, t.AssertJSONValue = kv[0], kv[1]
+		return nil
+	},
+	"CERT_WARNING_DAYS": func(t *Target, value string) (err error) {
+		t.CertWarningDays, err = strconv.Atoi(value)
+		return
+	},
+	"CA_FILE": func(t *Target, value string) error {
+		t.CAFile = value
+		return nil
+	},
+	"CLIENT_CERT": func(t *Target, value string) error {
+		t.ClientCert = value
+		return nil
+	},
+	"CLIENT_KEY": func(t *Target, value string) error {
+		t.ClientKey = value
+		return nil
+	},
+	"SERVER_NAME": func(t *Target, value string) error {
+		t.ServerName = value
+		return nil
+	},
+	"INSECURE_SKIP_VERIFY": func(t *Target, value string) (err error) {
+		t.InsecureSkipVerify, err = strconv.ParseBool(value)
+		return
+	},
+	"PROXY": func(t *Target, value string) error {
+		proxyURL, err := url.Parse(value)
+		if urlErr, ok := err.(*url.Error); ok {
+			// The parse error holds the whole URL, password included
+			return urlErr.Err
+		} else if err != nil {
+			return err
+		}
+		if proxyURL.Host == "" {
+			return fmt.Errorf("expected a proxy URL, eg. http://proxy:3128")
+		}
+
+		t.Proxy = value
+		return nil
+	},
+	"PROTOCOL": func(t *Target, value string) error {
+		switch value = strings.ToLower(value); value {
+		case "http1", "h2", "h2c":
+			t.Protocol = value
+			return nil
+		}
+
+		return fmt.Errorf("expected http1, h2 or h2c")
+	},
+	"RETRIES": func(t *Target, value string) error {
+		retries, err := strconv.Atoi(value)
+		if err == nil && retries < 0 {
+			err = fmt.Errorf("cannot retry a negative number of times")
+		}
+		if err != nil {
+			return err
+		}
+
+		t.Retries = retries
+		return nil
+	},
+	"RETRY_BACKOFF": func(t *Target, value string) (err error) {
+		t.RetryBackoff, err = time.ParseDuration(value)
+		return
+	},
+	"IP_VERSION": func(t *Target, value string) (err error) {
+		t.IPVersions, err = parseIPVersions(value)
+		return
+	},
+	"RESOLVE": func(t *Target, value string) error {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return fmt.Errorf("expected an IP address")
+		}
+
+		t.Resolve = ip.String()
+		return nil
+	},
+	"SAMPLES": func(t *Target, value string) (err error) {
+		t.Samples, err = parseSamples(value)
+		return
+	},
+	"PERCENTILE": func(t *Target, value string) (err error) {
+		t.Percentile, err = parsePercentile(value)
+		return
+	},
+}
+
+// targetPrefixOptions maps the TARGET_<NAME>_<OPTION>_<KEY> infixes to the
+// function applying their key and value to the target.
+var targetPrefixOptions = map[string]func(t *Target, key, value string) error{
+	"HEADER": func(t *Target, key, value string) error {
+		// HEADER_<X>_FILE reads the value of the X header from a file
+		if strings.HasSuffix(strings.ToLower(key), "_file") {
+			secret, err := readSecretFile(value)
+			if err != nil {
+				return err
+			}
+			key, value = key[:len(key)-len("_file")], secret
+			t.SecretHeaders = append(t.SecretHeaders, http.CanonicalHeaderKey(strings.Replace(key, "_", "-", -1)))
+		}
+
+		t.Headers.Add(strings.Replace(key, "_", "-", -1), value)
+		return nil
+	},
+	"WARN": func(t *Target, key, value string) error {
+		return setThreshold(t.Warning, key, value)
+	},
+	"CRIT": func(t *Target, key, value string) error {
+		return setThreshold(t.Critical, key, value)
+	},
+}
+
+// HasAssertions returns true if the target response body is to be checked
+func (t Target) HasAssertions() bool {
+	return t.AssertContains != "" || t.AssertRegexp != nil || t.AssertJSONPath != ""
+}
+
+// RedactedURI returns the target URI safe to display, see RedactURI
+func (t Target) RedactedURI() string {
+	return RedactURI(t.URI)
+}
+
+// RedactURI returns the URI with its password replaced by "xxxxx" so it can
+// be displayed, eg. in error messages
+func RedactURI(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+
+	// The URI may be invalid or lack its scheme and still hold a password
+	prefix, rest := "", uri
+	if i := strings.Index(uri, "://"); i >= 0 {
+		prefix, rest = uri[:i+3], uri[i+3:]
+	}
+
+	authority := rest
+	if end := strings.IndexAny(rest, "/?#"); end >= 0 {
+		authority = rest[:end]
+	}
+	at := strings.LastIndex(authority, "@")
+	colon := strings.Index(authority, ":")
+	if at < 0 || colon < 0 || colon > at {
+		return uri
+	}
+
+	return prefix + rest[:colon+1] + "xxxxx" + rest[at:]
+}
+
+// readSecretFile returns the content of a file holding a secret, without the
+// trailing newline
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// IsTLS returns true if the target is requested over TLS
+func (t Target) IsTLS() bool {
+	return strings.HasPrefix(strings.ToLower(t.URI), "https:")
+}
+
+// NewTarget creates a Target for the given URI with default options
+func NewTarget(uri string) Target {
+	return Target{
+		URI:             uri,
+		Method:          http.MethodGet,
+		CertWarningDays: defaultCertWarningDays,
+		Headers:         make(http.Header),
+		Warning:         make(map[string]time.Duration),
+		Critical:        make(map[string]time.Duration),
+	}
+}
+
+// setThreshold parses and stores the threshold of the given phase
+func setThreshold(thresholds map[string]time.Duration, phase, value string) error {
+	threshold, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	thresholds[phase] = threshold
+	return nil
+}
+
+// parseSamples parses a strictly positive number of samples
+func parseSamples(value string) (int, error) {
+	samples, err := strconv.Atoi(value)
+	if err == nil && samples < 1 {
+		err = fmt.Errorf("at least one sample is required")
+	}
+
+	return samples, err
+}
+
+// parseIPVersions parses 4, 6 or both into the IP versions to probe
+func parseIPVersions(value string) ([]int, error) {
+	switch strings.ToLower(value) {
+	case "4":
+		return []int{4}, nil
+	case "6":
+		return []int{6}, nil
+	case "both":
+		return []int{4, 6}, nil
+	}
+
+	return nil, fmt.Errorf("expected 4, 6 or both")
+}
+
+// IsDualStack returns true if the target is probed over both IPv4 and IPv6
+func (t Target) IsDualStack() bool {
+	return len(t.IPVersions) > 1
+}
+
+// parseDNSServer parses an ip:port address, the port defaulting to 53
+func parseDNSServer(value string) (string, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = value, "53"
+	}
+
+	if _, portErr := strconv.ParseUint(port, 10, 16); net.ParseIP(host) == nil || portErr != nil {
+		return "", fmt.Errorf("expected an ip:port address")
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// parsePercentile parses a percentile in the ]0, 100] range
+func parsePercentile(value string) (float64, error) {
+	percentile, err := strconv.ParseFloat(value, 64)
+	if err == nil && (percentile <= 0 || percentile > 100) {
+		err = fmt.Errorf("percentile must be in the ]0, 100] range")
+	}
+
+	return percentile, err
+}
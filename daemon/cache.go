@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+// cache is the content of the cache file
+type cache struct {
+	Updated time.Time             `json:"updated"`
+	Results []*pinger.RequestInfo `json:"results"`
+}
+
+// cachePath returns the path of the cache file, there is one per graph name
+// so multiple plugin instances can share the same state directory
+func cachePath(config config.Config) string {
+	return filepath.Join(config.StateDir, config.GetGraphName()+".cache.json")
+}
+
+// writeCache atomically replaces the cache file with the given results
+func writeCache(config config.Config, results []*pinger.RequestInfo) error {
+	data, err := json.Marshal(cache{Updated: time.Now(), Results: results})
+	if err != nil {
+		return err
+	}
+
+	path := cachePath(config)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadResults returns the latest results written by the daemon for the
+// configured targets
+// An error is returned if there are none or if they are older than three
+// probe intervals, meaning the daemon is not running anymore.
+func ReadResults(config config.Config) ([]*pinger.RequestInfo, error) {
+	data, err := ioutil.ReadFile(cachePath(config))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the daemon results: %s", err)
+	}
+
+	var c cache
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("Unable to read the daemon results: %s", err)
+	}
+
+	if age := time.Since(c.Updated); age > 3*config.ProbeInterval {
+		return nil, fmt.Errorf("The daemon results are stale, last update was %v ago", age)
+	}
+
+	results := make([]*pinger.RequestInfo, 0, len(c.Results))
+	for _, info := range c.Results {
+		if _, ok := config.Targets[info.Name]; ok {
+			results = append(results, info)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+func newTestConfig(t *testing.T) (config.Config, func()) {
+	dir, err := ioutil.TempDir("", "http-timing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := config.Config{
+		Targets: map[string]config.Target{
+			"example": config.NewTarget("https://example.com/"),
+		},
+		ProbeInterval: time.Minute,
+		StateDir:      dir,
+	}
+
+	return config, func() { os.RemoveAll(dir) }
+}
+
+func newRequestInfo(name string) *pinger.RequestInfo {
+	info := pinger.NewRequestInfo()
+	info.Name = name
+	info.StatusCode = 200
+	info.Total = 42 * time.Millisecond
+
+	return info
+}
+
+func TestCache(t *testing.T) {
+	config, remove := newTestConfig(t)
+	defer remove()
+
+	if _, err := ReadResults(config); err == nil {
+		t.Error("Expected an error without cache.")
+	}
+
+	results := []*pinger.RequestInfo{newRequestInfo("example"), newRequestInfo("removed")}
+	if err := writeCache(config, results); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := ReadResults(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 1 || cached[0].Name != "example" || cached[0].Total != 42*time.Millisecond {
+		t.Errorf("Unexpected cached results: %v", cached)
+	}
+
+	files, _ := ioutil.ReadDir(config.StateDir)
+	if len(files) != 1 {
+		t.Error("Expected a single file in the state directory, got ", len(files))
+	}
+}
+
+func TestStaleCache(t *testing.T) {
+	config, remove := newTestConfig(t)
+	defer remove()
+
+	if err := writeCache(config, []*pinger.RequestInfo{newRequestInfo("example")}); err != nil {
+		t.Fatal(err)
+	}
+
+	config.ProbeInterval = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, err := ReadResults(config); err == nil {
+		t.Error("Expected an error for stale results.")
+	}
+}
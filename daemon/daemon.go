@@ -0,0 +1,51 @@
+package daemon
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+var stderr = log.New(os.Stderr, "", 0)
+
+// Run pings all the targets forever every config.ProbeInterval and caches
+// the results for the munin fetch to read them, also spooling them when
+// supersampling
+func Run(config config.Config) error {
+	if len(config.Targets) <= 0 {
+		return errors.New("No URIs provided.")
+	}
+
+	ticker := time.NewTicker(config.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		results := probe(config)
+		if err := writeCache(config, results); err != nil {
+			stderr.Print(err)
+		}
+		if config.SupersamplingEnabled {
+			if err := appendSpool(config, results); err != nil {
+				stderr.Print(err)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// probe pings all the targets once and returns the results
+func probe(config config.Config) []*pinger.RequestInfo {
+	requests := pinger.PingAll(config)
+	for _, info := range requests {
+		if info.Error != nil {
+			stderr.Print(info.Error)
+		}
+	}
+
+	return requests
+}
@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+// spoolPath returns the path of the spool file holding the results gathered
+// since the last fetch, one JSON array of results per line
+func spoolPath(config config.Config) string {
+	return filepath.Join(config.StateDir, config.GetGraphName()+".spool.jsonl")
+}
+
+// appendSpool appends the given results to the spool file
+func appendSpool(config config.Config, results []*pinger.RequestInfo) error {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+
+	f, err := openLocked(spoolPath(config), os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// DrainSpool returns all the results gathered by the daemon for the
+// configured targets since the last call and empties the spool so no result
+// is returned twice
+func DrainSpool(config config.Config) ([]*pinger.RequestInfo, error) {
+	f, err := openLocked(spoolPath(config), os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the daemon results: %s", err)
+	}
+	defer f.Close()
+
+	results := make([]*pinger.RequestInfo, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var round []*pinger.RequestInfo
+		if err := json.Unmarshal(scanner.Bytes(), &round); err != nil {
+			stderr.Printf("Ignoring invalid daemon results: %s\n", err)
+			continue
+		}
+
+		for _, info := range round {
+			if _, ok := config.Targets[info.Name]; ok {
+				results = append(results, info)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read the daemon results: %s", err)
+	}
+
+	return results, f.Truncate(0)
+}
+
+// openLocked opens the given file and acquires an exclusive lock on it, the
+// lock is released when the file is closed
+func openLocked(path string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
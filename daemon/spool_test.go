@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+func TestSpool(t *testing.T) {
+	config, remove := newTestConfig(t)
+	defer remove()
+
+	results, err := DrainSpool(config)
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected an empty spool, got %v %v", results, err)
+	}
+
+	appendSpool(config, []*pinger.RequestInfo{newRequestInfo("example"), newRequestInfo("removed")})
+	appendSpool(config, []*pinger.RequestInfo{newRequestInfo("example")})
+
+	results, err = DrainSpool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Name != "example" || results[1].Name != "example" {
+		t.Errorf("Expected two results for the configured target, got %v", results)
+	}
+
+	if results, _ = DrainSpool(config); len(results) != 0 {
+		t.Errorf("Expected the spool to be drained, got %v", results)
+	}
+}
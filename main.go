@@ -6,7 +6,11 @@ import (
 	"os"
 
 	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/daemon"
 	"github.com/DigitalBackstage/munin-http-timing/munin"
+	"github.com/DigitalBackstage/munin-http-timing/nagios"
+	"github.com/DigitalBackstage/munin-http-timing/prometheus"
+	"github.com/DigitalBackstage/munin-http-timing/report"
 )
 
 var stdout = log.New(os.Stdout, "", 0)
@@ -23,18 +27,32 @@ func main() {
 	config.SetSuffixFromArg0(os.Args[0])
 
 	switch {
+	case len(os.Args) == 3 && os.Args[1] == "probe":
+		out, err = report.DoProbe(config, os.Args[2])
 	case len(os.Args) > 2:
 		fallthrough
 	default:
 		stderr.Print(usage())
 		os.Exit(1)
+	case len(os.Args) == 1 && config.OutputFormat == "json":
+		out, err = report.DoJSON(config)
 	case len(os.Args) == 1:
 		out, err = munin.DoPing(config)
+	case os.Args[1] == "json":
+		out, err = report.DoJSON(config)
 	case os.Args[1] == "config":
 		err = munin.DoConfig(config)
 		if config.ConfigAndPing && err == nil {
 			out, err = munin.DoPing(config)
 		}
+	case os.Args[1] == "check":
+		out, status := nagios.DoCheck(config)
+		stdout.Print(out)
+		os.Exit(status)
+	case os.Args[1] == "daemon":
+		err = daemon.Run(config)
+	case os.Args[1] == "serve":
+		err = prometheus.Serve(config)
 	case os.Args[1] == "autoconf":
 		out = "no" +
 			" (This module is meant to run outside of the node hosting the URIs" +
@@ -54,5 +72,5 @@ func main() {
 
 // usage returns the usage string (help)
 func usage() string {
-	return fmt.Sprintf("Usage: %s [config|autoconf|version]\n", os.Args[0])
+	return fmt.Sprintf("Usage: %s [config|autoconf|check|daemon|json|serve|version|probe <url>]\n", os.Args[0])
 }
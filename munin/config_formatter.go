@@ -2,11 +2,15 @@ package munin
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+	"github.com/DigitalBackstage/munin-http-timing/state"
 )
 
 var stdout = log.New(os.Stdout, "", 0)
@@ -14,8 +18,12 @@ var stdout = log.New(os.Stdout, "", 0)
 // Field order for graph_order and field descriptions
 // This is also hard-coded in Ping
 var graphOrder = []string{
+	"redirecting",
 	"resolving",
 	"connecting",
+	"proxy_connect",
+	"tunnel_established",
+	"tls",
 	"sending",
 	"waiting",
 	"receiving",
@@ -23,14 +31,22 @@ var graphOrder = []string{
 
 // DoConfig prints the munin plugin configuration to stdout
 func DoConfig(config config.Config) error {
-	if len(config.URIs) <= 0 {
+	if len(config.Targets) <= 0 {
 		return errors.New("No URIs provided.")
 	}
 
 	printMainGraph(config)
+	printAssertionsGraph(config)
+	printRetriesGraph(config)
+	printErrorsGraph(config)
+	printAvailabilityGraph(config)
 
-	for name, uri := range config.URIs {
-		printURIGraph(name, uri, config.GetGraphName())
+	for name, target := range config.Targets {
+		printURIGraph(name, target, config)
+		printResponseGraphs(name, target, config)
+		if target.IsTLS() {
+			printCertGraph(name, target, config)
+		}
 	}
 
 	return nil
@@ -40,6 +56,7 @@ func DoConfig(config config.Config) error {
 func printMainGraph(config config.Config) {
 	p := stdout.Println
 	p("multigraph " + config.GetGraphName())
+	printSupersampling(config)
 	p("graph_title Total time")
 	p("graph_category network")
 	p("graph_args --base 1000 -l 0")
@@ -48,42 +65,263 @@ func printMainGraph(config config.Config) {
 	p("graph_vlabel Time (ms)")
 	stdout.Printf("graph_order %s\n", strings.Join(graphOrder, " "))
 
-	for name, url := range config.URIs {
-		stdout.Printf("%s_total.label %s\n", name, url)
+	for name, target := range config.Targets {
+		if !target.IsDualStack() {
+			stdout.Printf("%s_total.label %s\n", name, target.RedactedURI())
+			printThresholds(name+"_total", "total", target)
+			continue
+		}
+
+		// One serie per IP version for dual-stack targets
+		for _, version := range target.IPVersions {
+			field := fmt.Sprintf("%s_v%d", name, version)
+			stdout.Printf("%s.label %s (IPv%d)\n", field, target.RedactedURI(), version)
+			printThresholds(field, "total", target)
+		}
+	}
+
+	p("")
+}
+
+// One serie per URI having assertions showing the number of failed ones
+func printAssertionsGraph(config config.Config) {
+	if !hasAssertions(config) {
+		return
+	}
+
+	p := stdout.Println
+	p("multigraph " + config.GetGraphName() + ".assertions")
+	printSupersampling(config)
+	p("graph_title Failed assertions")
+	p("graph_category network")
+	p("graph_args --base 1000 -l 0")
+	p("graph_scale no")
+	p("graph_info This graph shows the number of requests whose response body did not satisfy the assertions.")
+	p("graph_vlabel Failed requests")
+
+	for name, target := range config.Targets {
+		if target.HasAssertions() {
+			stdout.Printf("%s_assertion_failed.label %s\n", name, target.RedactedURI())
+			stdout.Printf("%s_assertion_failed.draw LINE1\n", name)
+		}
+	}
+
+	p("")
+}
+
+// hasAssertions returns true if any of the targets has assertions
+func hasAssertions(config config.Config) bool {
+	for _, target := range config.Targets {
+		if target.HasAssertions() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// One serie per URI retried on failure showing the number of retries
+func printRetriesGraph(config config.Config) {
+	if !hasRetries(config) {
+		return
+	}
+
+	p := stdout.Println
+	p("multigraph " + config.GetGraphName() + ".retries")
+	printSupersampling(config)
+	p("graph_title Retries")
+	p("graph_category network")
+	p("graph_args --base 1000 -l 0")
+	p("graph_scale no")
+	p("graph_info This graph shows the number of failed attempts before the last request.")
+	p("graph_vlabel Retries")
+
+	for name, target := range config.Targets {
+		if target.Retries > 0 {
+			stdout.Printf("%s_retries.label %s\n", name, target.RedactedURI())
+			stdout.Printf("%s_retries.draw LINE1\n", name)
+		}
+	}
+
+	p("")
+}
+
+// hasRetries returns true if any of the targets is retried on failure
+func hasRetries(config config.Config) bool {
+	for _, target := range config.Targets {
+		if target.Retries > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// One serie per error class per URI set to 1 when the request failed with
+// this class of error
+func printErrorsGraph(config config.Config) {
+	p := stdout.Println
+	p("multigraph " + config.GetGraphName() + ".errors")
+	printSupersampling(config)
+	p("graph_title Errors")
+	p("graph_category network")
+	p("graph_args --base 1000 -l 0")
+	p("graph_scale no")
+	p("graph_info This graph shows why the requests failed.")
+	p("graph_vlabel Failed requests")
+
+	for name, target := range config.Targets {
+		for _, class := range pinger.ErrorClasses {
+			stdout.Printf("%s_%s.label %s %s\n", name, class, target.RedactedURI(), class)
+			stdout.Printf("%s_%s.draw AREASTACK\n", name, class)
+		}
+	}
+
+	p("")
+}
+
+// One serie per availability window per URI showing the percentage of
+// successful requests
+func printAvailabilityGraph(config config.Config) {
+	p := stdout.Println
+	p("multigraph " + config.GetGraphName() + ".availability")
+	printSupersampling(config)
+	p("graph_title Availability")
+	p("graph_category network")
+	p("graph_args --base 1000 -l 0 -u 100")
+	p("graph_scale no")
+	p("graph_info This graph shows the percentage of successful requests over the last hour, day and week.")
+	p("graph_vlabel %")
+
+	for name, target := range config.Targets {
+		for _, window := range state.Windows {
+			stdout.Printf("%s_%s.label %s %s\n", name, window.Name, target.RedactedURI(), window.Name)
+			stdout.Printf("%s_%s.draw LINE1\n", name, window.Name)
+		}
 	}
 
 	p("")
 }
 
 // One serie per timing category per URI
-func printURIGraph(name, uri, graphName string) {
+func printURIGraph(name string, target config.Target, config config.Config) {
 	p := stdout.Printf
-	p("multigraph %s.%s\n", graphName, name)
-	p("graph_title Timings for %s\n", uri)
+	p("multigraph %s.%s\n", config.GetGraphName(), name)
+	printSupersampling(config)
+	p("graph_title Timings for %s\n", target.RedactedURI())
 	p("graph_vlabel Time (ms)\n")
-	printFields(name)
+	printFields(target, config.MinMaxEnabled)
 }
 
-func printFields(name string) {
+// Status code and body size graphs per URI
+func printResponseGraphs(name string, target config.Target, config config.Config) {
+	p := stdout.Printf
+	p("multigraph %s.%s_status\n", config.GetGraphName(), name)
+	printSupersampling(config)
+	p("graph_title HTTP status for %s\n", target.RedactedURI())
+	p("graph_args --base 1000 -l 0\n")
+	p("graph_scale no\n")
+	p("graph_vlabel Status code\n")
+	p("status.label Status\n")
+	p("status.draw LINE1\n")
+	p("status.info HTTP status code of the response.\n")
+	p("\n")
+
+	p("multigraph %s.%s_size\n", config.GetGraphName(), name)
+	printSupersampling(config)
+	p("graph_title Response size for %s\n", target.RedactedURI())
+	p("graph_args --base 1024 -l 0\n")
+	p("graph_vlabel Size (bytes)\n")
+	p("size.label Size\n")
+	p("size.draw AREA\n")
+	p("size.info Size of the response body.\n")
+	p("\n")
+}
+
+// Days left before the certificates expiration per TLS URI
+func printCertGraph(name string, target config.Target, config config.Config) {
+	p := stdout.Printf
+	p("multigraph %s.%s_cert\n", config.GetGraphName(), name)
+	printSupersampling(config)
+	p("graph_title Certificate expiration for %s\n", target.RedactedURI())
+	p("graph_args --base 1000\n")
+	p("graph_scale no\n")
+	p("graph_vlabel Days left\n")
+	p("days_left.label Certificate\n")
+	p("days_left.info Days left before the server certificate expires.\n")
+	p("days_left.warning %d:\n", target.CertWarningDays)
+	p("intermediate_days_left.label Intermediate\n")
+	p("intermediate_days_left.info Days left before the first intermediate certificate of the chain expires.\n")
+	p("intermediate_days_left.warning %d:\n", target.CertWarningDays)
+	p("\n")
+}
+
+func printFields(target config.Target, withMinMax bool) {
 	labels := map[string]string{
-		"Resolving":  "Time spent resolving the domain name.",
-		"Connecting": "Time spent initiating the TCP connection.",
-		"Sending":    "Time spent sending the HTTP request.",
-		"Waiting":    "Time spent waiting for the first byte of the HTTP response.",
-		"Receiving":  "Time spend receiving the request body.",
+		"Redirecting":        "Time spent following redirections before the final request.",
+		"Resolving":          "Time spent resolving the domain name.",
+		"Connecting":         "Time spent initiating the TCP connection.",
+		"Proxy connect":      "Time spent initiating the TCP connection to the proxy.",
+		"Tunnel established": "Time spent waiting for the proxy to connect to the target.",
+		"TLS":                "Time spent performing the TLS handshake.",
+		"Sending":            "Time spent sending the HTTP request.",
+		"Waiting":            "Time spent waiting for the first byte of the HTTP response.",
+		"Receiving":          "Time spend receiving the request body.",
 	}
 
 	for _, field := range graphOrder {
-		label := strings.ToUpper(field[0:1]) + field[1:]
+		label := fieldLabel(field)
 		stdout.Printf("%s.label %s\n", field, label)
 
-		if field == "resolving" {
+		if field == graphOrder[0] {
 			stdout.Printf("%s.draw AREA\n", field)
 		} else {
 			stdout.Printf("%s.draw STACK\n", field)
 		}
 		stdout.Printf("%s.info %s\n", field, labels[label])
+		printThresholds(field, field, target)
+	}
+
+	if withMinMax {
+		stdout.Println("total_min.label Min")
+		stdout.Println("total_min.draw LINE1")
+		stdout.Println("total_min.info Shortest total time among the samples.")
+		stdout.Println("total_max.label Max")
+		stdout.Println("total_max.draw LINE1")
+		stdout.Println("total_max.info Longest total time among the samples.")
 	}
 
 	stdout.Println("")
 }
+
+// printSupersampling prints the graph update rate and data size when the
+// values are reported for every sample gathered by the daemon
+// http://guide.munin-monitoring.org/en/latest/plugin/supersampling.html
+func printSupersampling(config config.Config) {
+	if !config.SupersamplingEnabled {
+		return
+	}
+
+	stdout.Printf("update_rate %d\n", int64(config.ProbeInterval/time.Second))
+	stdout.Printf("graph_data_size %s\n", config.GraphDataSize)
+}
+
+// printThresholds prints the warning and critical attributes of a field
+// from the thresholds of the given phase, in miliseconds
+func printThresholds(field, phase string, target config.Target) {
+	if threshold, ok := target.Warning[phase]; ok {
+		stdout.Printf("%s.warning %d\n", field, toMillisecond(threshold))
+	}
+	if threshold, ok := target.Critical[phase]; ok {
+		stdout.Printf("%s.critical %d\n", field, toMillisecond(threshold))
+	}
+}
+
+// fieldLabel returns the human readable label of a field
+func fieldLabel(field string) string {
+	if field == "tls" {
+		return "TLS"
+	}
+
+	return strings.ToUpper(field[0:1]) + strings.Replace(field[1:], "_", " ", -1)
+}
@@ -3,36 +3,54 @@ package munin
 import (
 	"errors"
 	"log"
-	"math/rand"
 	"os"
 	"time"
 
 	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/daemon"
 	"github.com/DigitalBackstage/munin-http-timing/pinger"
+	"github.com/DigitalBackstage/munin-http-timing/state"
 )
 
 var stderr = log.New(os.Stderr, "", 0)
 
 // DoPing calls the pinger and returns the response formatted for munin
 func DoPing(config config.Config) (string, error) {
-	rand.Seed(time.Now().Unix())
-
-	if len(config.URIs) <= 0 {
+	if len(config.Targets) <= 0 {
 		return "", errors.New("No URIs provided.")
 	}
 
-	requests := make([]*pinger.RequestInfo, 0, len(config.URIs))
-	queue := make(chan *pinger.RequestInfo, len(config.URIs))
-	pinger.DoParallelPings(config, queue)
+	requests, err := getRequests(config)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	history, err := state.Update(config, requests, now)
+	if err != nil {
+		stderr.Print(err)
+	}
+
+	return formatMultigraph(requests, config) + formatAvailability(history, config, now), nil
+}
 
-	for i := 0; i < len(config.URIs); i++ {
-		info := <-queue
+// getRequests returns the results gathered by the daemon when enabled, or
+// pings the targets
+func getRequests(config config.Config) ([]*pinger.RequestInfo, error) {
+	if config.SupersamplingEnabled {
+		return daemon.DrainSpool(config)
+	}
+
+	if config.DaemonEnabled {
+		return daemon.ReadResults(config)
+	}
+
+	requests := pinger.PingAll(config)
+	for _, info := range requests {
 		if info.Error != nil {
 			stderr.Print(info.Error)
 		}
-
-		requests = append(requests, info)
 	}
 
-	return formatMultigraph(requests, config.GetGraphName()), nil
+	return requests, nil
 }
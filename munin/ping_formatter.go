@@ -6,74 +6,298 @@ import (
 	"sort"
 	"time"
 
+	"github.com/DigitalBackstage/munin-http-timing/config"
 	"github.com/DigitalBackstage/munin-http-timing/pinger"
+	"github.com/DigitalBackstage/munin-http-timing/state"
 )
 
-// requestInfoToString returns the timings following the Munin multigraph protocol
+// formatRequestInfo returns the timings of a single target following the
+// Munin multigraph protocol, one set of values per RequestInfo
 // It prints the fields in a specific order, it must match the one in
 // graphOrder in config.go
-func formatRequestInfo(t *pinger.RequestInfo, graphName string) string {
+func formatRequestInfo(requests []*pinger.RequestInfo, config config.Config) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "multigraph %s.%s\n", config.GetGraphName(), requests[0].Name)
+	for _, t := range requests {
+		formatTimings(buf, t, config)
+	}
+
+	// Show where the latest request went and how so a change of backend or
+	// protocol is visible
+	last := requests[len(requests)-1]
+	last.Lock()
+	if remote := last.ConnectionSummary(); remote != "" {
+		if last.Proto != "" {
+			remote += " using " + last.Proto
+		}
+		fmt.Fprintf(buf, "connecting.extinfo Connected to %s\n", remote)
+	}
+	last.Unlock()
+	fmt.Fprint(buf, "\n")
+
+	return buf.String()
+}
+
+func formatTimings(buf *bytes.Buffer, t *pinger.RequestInfo, config config.Config) {
 	t.Lock()
 	defer t.Unlock()
 
-	buf := &bytes.Buffer{}
-	fmt.Fprintf(buf, "multigraph %s.%s\n", graphName, t.Name)
-
+	p := valuePrefix(t, config)
 	if t.IsOk() {
-		fmt.Fprintf(buf, "resolving.value %v\n", toMillisecond(t.Resolving))
-		fmt.Fprintf(buf, "connecting.value %v\n", toMillisecond(t.Connecting))
-		fmt.Fprintf(buf, "sending.value %v\n", toMillisecond(t.Sending))
-		fmt.Fprintf(buf, "waiting.value %v\n", toMillisecond(t.Waiting))
-		fmt.Fprintf(buf, "receiving.value %v\n", toMillisecond(t.Receiving))
+		fmt.Fprintf(buf, "redirecting.value %s%v\n", p, toMillisecond(t.Redirecting))
+		fmt.Fprintf(buf, "resolving.value %s%v\n", p, toMillisecond(t.Resolving))
+		fmt.Fprintf(buf, "connecting.value %s%v\n", p, toMillisecond(t.Connecting))
+		fmt.Fprintf(buf, "proxy_connect.value %s%v\n", p, toMillisecond(t.ProxyConnect))
+		fmt.Fprintf(buf, "tunnel_established.value %s%v\n", p, toMillisecond(t.TunnelEstablished))
+		fmt.Fprintf(buf, "tls.value %s%v\n", p, toMillisecond(t.TLSHandshake))
+		fmt.Fprintf(buf, "sending.value %s%v\n", p, toMillisecond(t.Sending))
+		fmt.Fprintf(buf, "waiting.value %s%v\n", p, toMillisecond(t.Waiting))
+		fmt.Fprintf(buf, "receiving.value %s%v\n", p, toMillisecond(t.Receiving))
 	} else {
-		fmt.Fprint(buf, "resolving.value U\n")
-		fmt.Fprint(buf, "connecting.value U\n")
-		fmt.Fprint(buf, "sending.value U\n")
-		fmt.Fprint(buf, "waiting.value U\n")
-		fmt.Fprint(buf, "receiving.value U\n")
+		fmt.Fprintf(buf, "redirecting.value %sU\n", p)
+		fmt.Fprintf(buf, "resolving.value %sU\n", p)
+		fmt.Fprintf(buf, "connecting.value %sU\n", p)
+		fmt.Fprintf(buf, "proxy_connect.value %sU\n", p)
+		fmt.Fprintf(buf, "tunnel_established.value %sU\n", p)
+		fmt.Fprintf(buf, "tls.value %sU\n", p)
+		fmt.Fprintf(buf, "sending.value %sU\n", p)
+		fmt.Fprintf(buf, "waiting.value %sU\n", p)
+		fmt.Fprintf(buf, "receiving.value %sU\n", p)
 	}
 
+	if config.MinMaxEnabled {
+		min, max, ok := t.TotalRange()
+		if ok {
+			fmt.Fprintf(buf, "total_min.value %s%v\n", p, toMillisecond(min))
+			fmt.Fprintf(buf, "total_max.value %s%v\n", p, toMillisecond(max))
+		} else {
+			fmt.Fprintf(buf, "total_min.value %sU\n", p)
+			fmt.Fprintf(buf, "total_max.value %sU\n", p)
+		}
+	}
+}
+
+// formatRequestInfoResponse returns the status code and body size graphs
+// values of a single target
+func formatRequestInfoResponse(requests []*pinger.RequestInfo, config config.Config) string {
+	status := &bytes.Buffer{}
+	size := &bytes.Buffer{}
+	fmt.Fprintf(status, "multigraph %s.%s_status\n", config.GetGraphName(), requests[0].Name)
+	fmt.Fprintf(size, "multigraph %s.%s_size\n", config.GetGraphName(), requests[0].Name)
+
+	for _, t := range requests {
+		t.Lock()
+		p := valuePrefix(t, config)
+		// No status code means no response was received at all
+		if t.StatusCode != 0 {
+			fmt.Fprintf(status, "status.value %s%d\n", p, t.StatusCode)
+			fmt.Fprintf(size, "size.value %s%d\n", p, t.BodySize)
+		} else {
+			fmt.Fprintf(status, "status.value %sU\n", p)
+			fmt.Fprintf(size, "size.value %sU\n", p)
+		}
+		t.Unlock()
+	}
+
+	return status.String() + "\n" + size.String() + "\n"
+}
+
+// formatRequestInfoCert returns the certificate graph values of a single
+// target, days are counted from the given time or from the request start
+// when supersampling
+func formatRequestInfoCert(requests []*pinger.RequestInfo, config config.Config, now time.Time) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "multigraph %s.%s_cert\n", config.GetGraphName(), requests[0].Name)
+
+	for _, t := range requests {
+		t.Lock()
+		p := valuePrefix(t, config)
+		if config.SupersamplingEnabled {
+			now = t.Start()
+		}
+		fmt.Fprintf(buf, "days_left.value %s%s\n", p, formatDaysLeft(t.CertExpiry, now))
+		fmt.Fprintf(buf, "intermediate_days_left.value %s%s\n", p, formatDaysLeft(t.IntermediateCertExpiry, now))
+		t.Unlock()
+	}
 	fmt.Fprint(buf, "\n")
 
 	return buf.String()
 }
 
-// TotalString returns the <name>_total.value line for this RequestInfo
-func formatRequestInfoTotal(t *pinger.RequestInfo) string {
+func formatDaysLeft(expiry, now time.Time) string {
+	if expiry.IsZero() {
+		return "U"
+	}
+
+	return fmt.Sprintf("%.2f", expiry.Sub(now).Hours()/24)
+}
+
+// formatRequestInfoTotal returns the <name>_total.value line for this
+// RequestInfo, or one <name>_v<version>.value line per IP version for
+// dual-stack targets
+func formatRequestInfoTotal(t *pinger.RequestInfo, config config.Config) string {
 	t.Lock()
 	defer t.Unlock()
 
-	value := "U"
-	if t.IsOk() {
-		value = fmt.Sprintf("%v", toMillisecond(t.Total))
+	p := valuePrefix(t, config)
+	target := config.Targets[t.Name]
+	if !target.IsDualStack() {
+		return fmt.Sprintf("%s_total.value %s%s\n", t.Name, p, formatTotal(t))
+	}
+
+	buf := &bytes.Buffer{}
+	for i, version := range target.IPVersions {
+		value := "U"
+		if i < len(t.Families) {
+			t.Families[i].Lock()
+			value = formatTotal(t.Families[i])
+			t.Families[i].Unlock()
+		}
+		fmt.Fprintf(buf, "%s_v%d.value %s%s\n", t.Name, version, p, value)
+	}
+
+	return buf.String()
+}
+
+// formatTotal returns the total time in milliseconds or U on failure
+func formatTotal(t *pinger.RequestInfo) string {
+	if !t.IsOk() {
+		return "U"
+	}
+
+	return fmt.Sprintf("%v", toMillisecond(t.Total))
+}
+
+// formatRequestInfoErrors returns one <name>_<class>.value line per error
+// class, set to 1 for the class of the error the request failed with
+func formatRequestInfoErrors(t *pinger.RequestInfo, config config.Config) string {
+	t.Lock()
+	defer t.Unlock()
+
+	buf := &bytes.Buffer{}
+	p := valuePrefix(t, config)
+	for _, class := range pinger.ErrorClasses {
+		value := 0
+		if t.Error != nil && t.ErrorClass == class {
+			value = 1
+		}
+		fmt.Fprintf(buf, "%s_%s.value %s%d\n", t.Name, class, p, value)
+	}
+
+	return buf.String()
+}
+
+// valuePrefix returns the timestamp to prefix the values with when
+// supersampling
+// http://guide.munin-monitoring.org/en/latest/plugin/supersampling.html
+func valuePrefix(t *pinger.RequestInfo, config config.Config) string {
+	if !config.SupersamplingEnabled {
+		return ""
 	}
 
-	return fmt.Sprintf("%s_total.value %v\n", t.Name, value)
+	return fmt.Sprintf("%d:", t.Start().Unix())
 }
 
 func toMillisecond(d time.Duration) int64 {
 	return int64(d / time.Millisecond)
 }
 
-func formatMultigraph(requests []*pinger.RequestInfo, graphName string) string {
+// formatMultigraph returns the values of all the graphs, there can be many
+// RequestInfo per target when supersampling
+func formatMultigraph(requests []*pinger.RequestInfo, config config.Config) string {
 	sort.Sort(requestByName(requests))
 
 	buf := &bytes.Buffer{}
-	for i := range requests {
-		fmt.Fprint(buf, formatRequestInfo(requests[i], graphName))
+	for _, targetRequests := range groupByName(requests) {
+		fmt.Fprint(buf, formatRequestInfo(targetRequests, config))
+		fmt.Fprint(buf, formatRequestInfoResponse(targetRequests, config))
+		if config.Targets[targetRequests[0].Name].IsTLS() {
+			fmt.Fprint(buf, formatRequestInfoCert(targetRequests, config, time.Now()))
+		}
 	}
 
-	fmt.Fprintf(buf, "multigraph %s\n", graphName)
+	fmt.Fprintf(buf, "multigraph %s\n", config.GetGraphName())
 	for _, value := range requests {
-		fmt.Fprintf(buf, formatRequestInfoTotal(value))
+		fmt.Fprint(buf, formatRequestInfoTotal(value, config))
+	}
+	fmt.Fprint(buf, "\n")
+
+	fmt.Fprintf(buf, "multigraph %s.errors\n", config.GetGraphName())
+	for _, value := range requests {
+		fmt.Fprint(buf, formatRequestInfoErrors(value, config))
+	}
+	fmt.Fprint(buf, "\n")
+
+	if hasAssertions(config) {
+		fmt.Fprintf(buf, "multigraph %s.assertions\n", config.GetGraphName())
+		for _, value := range requests {
+			if config.Targets[value.Name].HasAssertions() {
+				fmt.Fprintf(buf, "%s_assertion_failed.value %s%d\n", value.Name, valuePrefix(value, config), value.FailedAssertions())
+			}
+		}
+		fmt.Fprint(buf, "\n")
+	}
+
+	if hasRetries(config) {
+		fmt.Fprintf(buf, "multigraph %s.retries\n", config.GetGraphName())
+		for _, value := range requests {
+			if config.Targets[value.Name].Retries > 0 {
+				fmt.Fprintf(buf, "%s_retries.value %s%d\n", value.Name, valuePrefix(value, config), value.Retries())
+			}
+		}
+		fmt.Fprint(buf, "\n")
+	}
+
+	return buf.String()
+}
+
+// formatAvailability returns the percentage of successful requests of each
+// target over every availability window
+func formatAvailability(history state.History, config config.Config, now time.Time) string {
+	names := make([]string, 0, len(config.Targets))
+	for name := range config.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "multigraph %s.availability\n", config.GetGraphName())
+	for _, name := range names {
+		for _, window := range state.Windows {
+			if percentage, ok := history.Availability(name, window.Duration, now); ok {
+				fmt.Fprintf(buf, "%s_%s.value %.2f\n", name, window.Name, percentage)
+			} else {
+				fmt.Fprintf(buf, "%s_%s.value U\n", name, window.Name)
+			}
+		}
 	}
 	fmt.Fprint(buf, "\n")
 
 	return buf.String()
 }
 
+// groupByName splits the sorted requests in one slice per target
+func groupByName(requests []*pinger.RequestInfo) [][]*pinger.RequestInfo {
+	groups := make([][]*pinger.RequestInfo, 0)
+	for i, info := range requests {
+		if i == 0 || info.Name != requests[i-1].Name {
+			groups = append(groups, []*pinger.RequestInfo{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], info)
+	}
+
+	return groups
+}
+
+// requestByName sorts requests by name, then by start time
 type requestByName []*pinger.RequestInfo
 
-func (a requestByName) Len() int           { return len(a) }
-func (a requestByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a requestByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a requestByName) Len() int      { return len(a) }
+func (a requestByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a requestByName) Less(i, j int) bool {
+	if a[i].Name != a[j].Name {
+		return a[i].Name < a[j].Name
+	}
+
+	return a[i].Start().Before(a[j].Start())
+}
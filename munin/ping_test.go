@@ -1,14 +1,20 @@
 package munin
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+	"github.com/DigitalBackstage/munin-http-timing/state"
 )
 
 func TestEmptyURIList(t *testing.T) {
 	config := config.Config{
-		URIs: map[string]string{},
+		Targets: map[string]config.Target{},
 	}
 	out, err := DoPing(config)
 
@@ -19,3 +25,242 @@ func TestEmptyURIList(t *testing.T) {
 		t.Error("Should get empty response from DoPing.")
 	}
 }
+
+func TestFormatRequestInfo(t *testing.T) {
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	info.StatusCode = 200
+	info.Connecting = 2 * time.Millisecond
+	info.TLSHandshake = 3 * time.Millisecond
+
+	expected := "multigraph timing.example\n" +
+		"redirecting.value 0\n" +
+		"resolving.value 0\n" +
+		"connecting.value 2\n" +
+		"proxy_connect.value 0\n" +
+		"tunnel_established.value 0\n" +
+		"tls.value 3\n" +
+		"sending.value 0\n" +
+		"waiting.value 0\n" +
+		"receiving.value 0\n" +
+		"\n"
+	if actual := formatRequestInfo([]*pinger.RequestInfo{info}, config.Config{}); actual != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", actual, expected)
+	}
+
+	info.StatusCode = 500
+	if actual := formatRequestInfo([]*pinger.RequestInfo{info}, config.Config{}); !strings.Contains(actual, "tls.value U\n") {
+		t.Errorf("Expected unknown TLS value on error, got:\n%s", actual)
+	}
+}
+
+func TestFormatRequestInfoExtinfo(t *testing.T) {
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	info.StatusCode = 200
+	info.RemoteAddr = "[::1]:443"
+	info.IPVersion = 6
+	info.ConnReused = true
+	info.Proto = "HTTP/2.0"
+
+	expected := "connecting.extinfo Connected to [::1]:443 (IPv6, reused connection) using HTTP/2.0\n"
+	if actual := formatRequestInfo([]*pinger.RequestInfo{info}, config.Config{}); !strings.Contains(actual, expected) {
+		t.Errorf("Expected %q in:\n%s", expected, actual)
+	}
+}
+
+func TestFormatRequestInfoMinMax(t *testing.T) {
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	info.StatusCode = 200
+	for _, total := range []time.Duration{30, 10, 20} {
+		sample := pinger.NewRequestInfo()
+		sample.Total = total * time.Millisecond
+		info.Samples = append(info.Samples, sample)
+	}
+
+	actual := formatRequestInfo([]*pinger.RequestInfo{info}, config.Config{MinMaxEnabled: true})
+	if !strings.Contains(actual, "total_min.value 10\ntotal_max.value 30\n") {
+		t.Errorf("Expected min and max values, got:\n%s", actual)
+	}
+
+	if actual := formatRequestInfo([]*pinger.RequestInfo{info}, config.Config{}); strings.Contains(actual, "total_min") {
+		t.Errorf("Unexpected min and max values, got:\n%s", actual)
+	}
+}
+
+func TestFormatRequestInfoResponse(t *testing.T) {
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	info.StatusCode = 503
+	info.BodySize = 1234
+
+	expected := "multigraph timing.example_status\n" +
+		"status.value 503\n" +
+		"\n" +
+		"multigraph timing.example_size\n" +
+		"size.value 1234\n" +
+		"\n"
+	if actual := formatRequestInfoResponse([]*pinger.RequestInfo{info}, config.Config{}); actual != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", actual, expected)
+	}
+
+	info.StatusCode = 0
+	if actual := formatRequestInfoResponse([]*pinger.RequestInfo{info}, config.Config{}); !strings.Contains(actual, "status.value U\n") {
+		t.Errorf("Expected unknown status without a response, got:\n%s", actual)
+	}
+}
+
+func TestFormatMultigraphAssertions(t *testing.T) {
+	target := config.NewTarget("https://example.com/")
+	target.AssertContains = "ok"
+	config := config.Config{
+		Targets: map[string]config.Target{
+			"checked":   target,
+			"unchecked": config.NewTarget("https://example.com/"),
+		},
+	}
+
+	checked := pinger.NewRequestInfo()
+	checked.Name = "checked"
+	checked.StatusCode = 200
+	checked.AssertionFailed = true
+	unchecked := pinger.NewRequestInfo()
+	unchecked.Name = "unchecked"
+	unchecked.StatusCode = 200
+
+	actual := formatMultigraph([]*pinger.RequestInfo{checked, unchecked}, config)
+	expected := "multigraph timing.assertions\nchecked_assertion_failed.value 1\n\n"
+	if !strings.HasSuffix(actual, expected) {
+		t.Errorf("Expected assertions graph, got:\n%s", actual)
+	}
+	if !strings.Contains(actual, "multigraph timing.checked\nredirecting.value U\n") {
+		t.Errorf("Expected unknown values on assertion failure, got:\n%s", actual)
+	}
+}
+
+func TestFormatMultigraphRetries(t *testing.T) {
+	target := config.NewTarget("https://example.com/")
+	target.Retries = 3
+	config := config.Config{
+		Targets: map[string]config.Target{
+			"retried": target,
+			"single":  config.NewTarget("https://example.com/"),
+		},
+	}
+
+	retried := pinger.NewRequestInfo()
+	retried.Name = "retried"
+	retried.StatusCode = 200
+	retried.Attempts = 3
+	single := pinger.NewRequestInfo()
+	single.Name = "single"
+	single.StatusCode = 200
+
+	actual := formatMultigraph([]*pinger.RequestInfo{retried, single}, config)
+	expected := "multigraph timing.retries\nretried_retries.value 2\n\n"
+	if !strings.HasSuffix(actual, expected) {
+		t.Errorf("Expected retries graph, got:\n%s", actual)
+	}
+}
+
+func TestFormatRequestInfoErrors(t *testing.T) {
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	info.Error = errors.New("Got a 503, unable to fetch http://example.com/")
+	info.ErrorClass = "http_5xx"
+
+	actual := formatRequestInfoErrors(info, config.Config{})
+	if !strings.Contains(actual, "example_http_5xx.value 1\n") || strings.Count(actual, ".value 0\n") != len(pinger.ErrorClasses)-1 {
+		t.Errorf("Expected only http_5xx to be set, got:\n%s", actual)
+	}
+}
+
+func TestFormatAvailability(t *testing.T) {
+	now := time.Now()
+	config := config.Config{Targets: map[string]config.Target{"example": config.NewTarget("https://example.com/")}}
+	history := state.History{Targets: map[string][]state.Check{
+		"example": {
+			{Time: now.Add(-2 * time.Hour).Unix(), Ok: false},
+			{Time: now.Add(-time.Minute).Unix(), Ok: true},
+		},
+	}}
+
+	expected := "multigraph timing.availability\n" +
+		"example_1h.value 100.00\n" +
+		"example_24h.value 50.00\n" +
+		"example_7d.value 50.00\n" +
+		"\n"
+	if actual := formatAvailability(history, config, now); actual != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestFormatRequestInfoCert(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	info.CertExpiry = now.Add(36 * time.Hour)
+
+	expected := "multigraph timing.example_cert\n" +
+		"days_left.value 1.50\n" +
+		"intermediate_days_left.value U\n" +
+		"\n"
+	if actual := formatRequestInfoCert([]*pinger.RequestInfo{info}, config.Config{}, now); actual != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestFormatMultigraphSupersampling(t *testing.T) {
+	config := config.Config{
+		Targets:              map[string]config.Target{"example": config.NewTarget("http://example.com/")},
+		SupersamplingEnabled: true,
+	}
+
+	requests := make([]*pinger.RequestInfo, 0)
+	for i := 0; i < 2; i++ {
+		info := pinger.NewRequestInfo()
+		info.RequestStart("example", "http://example.com/")
+		info.StatusCode = 200
+		info.BodySize = 10
+		requests = append(requests, info)
+	}
+
+	first, second := requests[0].Start().Unix(), requests[1].Start().Unix()
+	actual := formatMultigraph(requests, config)
+
+	if strings.Count(actual, "multigraph timing.example\n") != 1 {
+		t.Errorf("Expected a single section per graph, got:\n%s", actual)
+	}
+
+	expected := fmt.Sprintf(
+		"multigraph timing.example_size\nsize.value %d:10\nsize.value %d:10\n\n",
+		first, second,
+	)
+	if !strings.Contains(actual, expected) {
+		t.Errorf("Expected timestamped values, got:\n%s", actual)
+	}
+	if !strings.Contains(actual, fmt.Sprintf("example_total.value %d:0\n", first)) {
+		t.Errorf("Expected timestamped totals, got:\n%s", actual)
+	}
+}
+
+func TestFormatRequestInfoTotalDualStack(t *testing.T) {
+	target := config.NewTarget("http://example.com/")
+	target.IPVersions = []int{4, 6}
+	config := config.Config{Targets: map[string]config.Target{"example": target}}
+
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	v4 := pinger.NewRequestInfo()
+	v4.StatusCode = 200
+	v4.Total = 12 * time.Millisecond
+	v6 := pinger.NewRequestInfo()
+	v6.Error = errors.New("dial tcp6: network is unreachable")
+	info.Families = []*pinger.RequestInfo{v4, v6}
+
+	expected := "example_v4.value 12\nexample_v6.value U\n"
+	if actual := formatRequestInfoTotal(info, config); actual != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,150 @@
+package nagios
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+// Plugin return codes
+// https://nagios-plugins.org/doc/guidelines.html#AEN78
+const (
+	OK       = 0
+	Warning  = 1
+	Critical = 2
+	Unknown  = 3
+)
+
+var statusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// DoCheck pings the targets, compares the timings against the targets
+// thresholds and returns the plugin output along with its return code
+func DoCheck(config config.Config) (string, int) {
+	if len(config.Targets) <= 0 {
+		return formatStatus(Unknown, []string{"No URIs provided."}, ""), Unknown
+	}
+
+	requests := pinger.PingAll(config)
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Name < requests[j].Name })
+
+	status := OK
+	messages := []string{}
+	perfdata := &bytes.Buffer{}
+	for _, info := range requests {
+		target := config.Targets[info.Name]
+		targetStatus, message := checkRequestInfo(info, target)
+		if targetStatus > status {
+			status = targetStatus
+		}
+		if targetStatus != OK {
+			messages = append(messages, message)
+		}
+
+		fmt.Fprint(perfdata, formatPerfdata(info, target))
+	}
+
+	if status == OK {
+		messages = append(messages, fmt.Sprintf("%d targets OK", len(requests)))
+	}
+
+	return formatStatus(status, messages, strings.TrimSpace(perfdata.String())), status
+}
+
+// checkRequestInfo returns the status of a single ping and a message
+// explaining it
+func checkRequestInfo(info *pinger.RequestInfo, target config.Target) (int, string) {
+	info.Lock()
+	defer info.Unlock()
+
+	if info.Error != nil {
+		return Critical, fmt.Sprintf("%s: %s", info.Name, strings.TrimSpace(info.Error.Error()))
+	}
+
+	if unknown := unknownPhases(target); len(unknown) > 0 {
+		return Unknown, fmt.Sprintf("%s: unknown threshold phases %s", info.Name, strings.Join(unknown, ", "))
+	}
+
+	status := OK
+	reasons := []string{}
+	for _, phase := range pinger.AllPhaseNames {
+		value := info.Phase(phase)
+		if threshold, ok := target.Critical[phase]; ok && value > threshold {
+			status = Critical
+			reasons = append(reasons, fmt.Sprintf("%s %v > %v", phase, value, threshold))
+		} else if threshold, ok := target.Warning[phase]; ok && value > threshold {
+			if status < Warning {
+				status = Warning
+			}
+			reasons = append(reasons, fmt.Sprintf("%s %v > %v", phase, value, threshold))
+		}
+	}
+
+	return status, fmt.Sprintf("%s: %s", info.Name, strings.Join(reasons, ", "))
+}
+
+// unknownPhases returns the threshold phases not matching any known phase
+func unknownPhases(target config.Target) []string {
+	known := make(map[string]bool, len(pinger.AllPhaseNames))
+	for _, phase := range pinger.AllPhaseNames {
+		known[phase] = true
+	}
+
+	unknown := []string{}
+	for _, thresholds := range []map[string]time.Duration{target.Warning, target.Critical} {
+		for phase := range thresholds {
+			if !known[phase] {
+				unknown = append(unknown, phase)
+			}
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// formatPerfdata returns the performance data of every phase of a ping
+// https://nagios-plugins.org/doc/guidelines.html#AEN200
+func formatPerfdata(info *pinger.RequestInfo, target config.Target) string {
+	info.Lock()
+	defer info.Unlock()
+
+	buf := &bytes.Buffer{}
+	for _, phase := range pinger.AllPhaseNames {
+		value := "U"
+		if info.IsOk() {
+			value = fmt.Sprintf("%vms", info.Phase(phase).Milliseconds())
+		}
+
+		fmt.Fprintf(
+			buf, "'%s_%s'=%s;%s;%s;0; ",
+			info.Name, phase, value,
+			formatThreshold(target.Warning, phase),
+			formatThreshold(target.Critical, phase),
+		)
+	}
+
+	return buf.String()
+}
+
+func formatThreshold(thresholds map[string]time.Duration, phase string) string {
+	threshold, ok := thresholds[phase]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", threshold.Milliseconds())
+}
+
+func formatStatus(status int, messages []string, perfdata string) string {
+	out := fmt.Sprintf("HTTP TIMING %s - %s", statusNames[status], strings.Join(messages, "; "))
+	if perfdata != "" {
+		out += " | " + perfdata
+	}
+
+	return out + "\n"
+}
@@ -0,0 +1,85 @@
+package nagios
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+func newRequestInfo(total time.Duration) *pinger.RequestInfo {
+	info := pinger.NewRequestInfo()
+	info.Name = "example"
+	info.StatusCode = 200
+	info.Waiting = total / 2
+	info.Total = total
+
+	return info
+}
+
+func TestCheckWithoutURIs(t *testing.T) {
+	var config config.Config
+	out, status := DoCheck(config)
+
+	if status != Unknown {
+		t.Error("Expected UNKNOWN status when given no URIs, got ", status)
+	}
+	if !strings.HasPrefix(out, "HTTP TIMING UNKNOWN - ") {
+		t.Error("Unexpected output: ", out)
+	}
+}
+
+func TestCheckRequestInfo(t *testing.T) {
+	target := config.NewTarget("https://example.com/")
+	target.Warning["total"] = 500 * time.Millisecond
+	target.Critical["total"] = time.Second
+	target.Warning["waiting"] = 100 * time.Millisecond
+
+	cases := []struct {
+		total    time.Duration
+		expected int
+	}{
+		{150 * time.Millisecond, OK},
+		{300 * time.Millisecond, Warning},
+		{600 * time.Millisecond, Warning},
+		{2 * time.Second, Critical},
+	}
+	for _, c := range cases {
+		if status, message := checkRequestInfo(newRequestInfo(c.total), target); status != c.expected {
+			t.Errorf("Expected status %d for %v, got %d (%s)", c.expected, c.total, status, message)
+		}
+	}
+
+	info := newRequestInfo(0)
+	info.Error = errors.New("Got a 500")
+	if status, _ := checkRequestInfo(info, target); status != Critical {
+		t.Error("Expected errors to be critical, got ", status)
+	}
+
+	target.Warning["totl"] = time.Second
+	if status, _ := checkRequestInfo(newRequestInfo(0), target); status != Unknown {
+		t.Error("Expected unknown phases to be reported, got ", status)
+	}
+}
+
+func TestFormatPerfdata(t *testing.T) {
+	target := config.NewTarget("https://example.com/")
+	target.Warning["total"] = 500 * time.Millisecond
+	target.Critical["total"] = time.Second
+
+	out := formatPerfdata(newRequestInfo(300*time.Millisecond), target)
+	for _, expected := range []string{"'example_waiting'=150ms;;;0; ", "'example_total'=300ms;500;1000;0; "} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected perfdata to contain %q, got %q", expected, out)
+		}
+	}
+
+	info := newRequestInfo(0)
+	info.StatusCode = 500
+	if out := formatPerfdata(info, target); !strings.Contains(out, "'example_total'=U;500;1000;0; ") {
+		t.Error("Expected unknown perfdata on failure, got ", out)
+	}
+}
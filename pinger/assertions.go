@@ -0,0 +1,82 @@
+package pinger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+// checkAssertions returns an error if the response body does not satisfy
+// the target assertions
+func checkAssertions(body []byte, target config.Target, uri string) error {
+	if target.AssertContains != "" && !bytes.Contains(body, []byte(target.AssertContains)) {
+		return fmt.Errorf("Response body of %s does not contain %q\n", uri, target.AssertContains)
+	}
+
+	if target.AssertRegexp != nil && !target.AssertRegexp.Match(body) {
+		return fmt.Errorf("Response body of %s does not match %q\n", uri, target.AssertRegexp)
+	}
+
+	if target.AssertJSONPath != "" {
+		value, err := getJSONPath(body, target.AssertJSONPath)
+		if err != nil {
+			return fmt.Errorf("Response body of %s: %s\n", uri, err)
+		}
+
+		if value != target.AssertJSONValue {
+			return fmt.Errorf(
+				"Response body of %s has %q at %s instead of %q\n",
+				uri, value, target.AssertJSONPath, target.AssertJSONValue,
+			)
+		}
+	}
+
+	return nil
+}
+
+// getJSONPath returns the value found at the given path in a JSON document
+// The path is made of dot-separated object keys and array indexes, with an
+// optional leading "$.", eg. "$.data.items.0.name". Strings are returned
+// as-is, other values in their JSON encoding.
+func getJSONPath(body []byte, path string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return "", fmt.Errorf("invalid JSON (%s)", err)
+	}
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch node := doc.(type) {
+			case map[string]interface{}:
+				value, ok := node[key]
+				if !ok {
+					return "", fmt.Errorf("no %s key in JSON path %s", key, path)
+				}
+				doc = value
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(node) {
+					return "", fmt.Errorf("no %s index in JSON path %s", key, path)
+				}
+				doc = node[i]
+			default:
+				return "", fmt.Errorf("cannot index %s in JSON path %s", key, path)
+			}
+		}
+	}
+
+	if str, ok := doc.(string); ok {
+		return str, nil
+	}
+
+	encoded, err := json.Marshal(doc)
+	return string(encoded), err
+}
@@ -0,0 +1,89 @@
+package pinger
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+func TestGetJSONPath(t *testing.T) {
+	body := []byte(`{"status": "ok", "count": 3, "items": [{"id": 1.5, "ok": true}], "none": null}`)
+
+	cases := map[string]string{
+		"status":       "ok",
+		"$.status":     "ok",
+		"count":        "3",
+		"items.0.id":   "1.5",
+		"$.items.0.ok": "true",
+		"none":         "null",
+		"items.0":      `{"id":1.5,"ok":true}`,
+		"$":            `{"count":3,"items":[{"id":1.5,"ok":true}],"none":null,"status":"ok"}`,
+	}
+	for path, expected := range cases {
+		actual, err := getJSONPath(body, path)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", path, err)
+		}
+		if actual != expected {
+			t.Errorf("Expected %s to be %q, got %q", path, expected, actual)
+		}
+	}
+
+	for _, path := range []string{"missing", "items.1", "items.x", "status.sub"} {
+		if _, err := getJSONPath(body, path); err == nil {
+			t.Errorf("Expected an error for %s", path)
+		}
+	}
+
+	if _, err := getJSONPath([]byte("<html>"), "status"); err == nil {
+		t.Error("Expected an error for invalid JSON.")
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	body := []byte(`{"status": "ok"}`)
+
+	target := config.NewTarget("https://example.com/")
+	if err := checkAssertions(body, target, target.URI); err != nil {
+		t.Error("Unexpected error without assertions: ", err)
+	}
+
+	target.AssertContains = `"ok"`
+	target.AssertRegexp = regexp.MustCompile(`"status":\s*"\w+"`)
+	target.AssertJSONPath, target.AssertJSONValue = "status", "ok"
+	if err := checkAssertions(body, target, target.URI); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+
+	failing := []func(target *config.Target){
+		func(target *config.Target) { target.AssertContains = "error" },
+		func(target *config.Target) { target.AssertRegexp = regexp.MustCompile(`^<html>`) },
+		func(target *config.Target) { target.AssertJSONValue = "ko" },
+	}
+	for i, fail := range failing {
+		failed := target
+		fail(&failed)
+		if err := checkAssertions(body, failed, failed.URI); err == nil {
+			t.Errorf("Expected assertion %d to fail", i)
+		}
+	}
+}
+
+func TestPingAssertions(t *testing.T) {
+	target := config.NewTarget(TestServerBaseURI + "/json")
+	target.AssertJSONPath, target.AssertJSONValue = "status", "ok"
+	info, err := ping("json", target, "test")
+	if err != nil || !info.IsOk() {
+		t.Error("Unexpected assertion failure: ", err)
+	}
+
+	target.AssertJSONValue = "ko"
+	info, err = ping("json", target, "test")
+	if err == nil || info.IsOk() || !info.AssertionFailed {
+		t.Error("Expected assertion failure.")
+	}
+	if info.FailedAssertions() != 1 {
+		t.Error("Expected one failed assertion, got ", info.FailedAssertions())
+	}
+}
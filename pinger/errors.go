@@ -0,0 +1,109 @@
+package pinger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ErrorClasses lists the classes a request failure can fall in, see
+// classifyError
+var ErrorClasses = []string{
+	"dns_failure",
+	"connect_refused",
+	"connect_timeout",
+	"tls_error",
+	"http_4xx",
+	"http_5xx",
+	"redirect",
+	"timeout",
+	"body_read_error",
+	"other",
+}
+
+// bodyReadError marks the errors happening while reading the response body
+type bodyReadError struct {
+	error
+}
+
+func (e bodyReadError) Unwrap() error {
+	return e.error
+}
+
+// classifyError returns the class of the error a request failed with, the
+// status code being used when a response was received
+func classifyError(err error, statusCode int) string {
+	var bodyErr bodyReadError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &bodyErr):
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return "timeout"
+		}
+		return "body_read_error"
+	case statusCode >= 500:
+		return "http_5xx"
+	case statusCode >= 400:
+		return "http_4xx"
+	case statusCode >= 300:
+		return "redirect"
+	case statusCode != 0:
+		// Unexpected status or failed assertion
+		return "other"
+	case errors.As(err, &dnsErr):
+		return "dns_failure"
+	case isTLSError(err):
+		return "tls_error"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		if opErr.Timeout() {
+			return "connect_timeout"
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return "connect_refused"
+		}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+
+	return "other"
+}
+
+// isTransient returns true if the request failed for a reason that may go
+// away when retrying: the connection could not be established, timed out or
+// was reset, but not when an error status or response was received
+func isTransient(err error, class string) bool {
+	switch class {
+	case "connect_refused", "connect_timeout", "timeout", "body_read_error":
+		return true
+	case "other":
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	return false
+}
+
+// isTLSError returns true if the error happened during the TLS handshake or
+// while verifying the peer certificates
+func isTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	var recordHeader tls.RecordHeaderError
+	var alert tls.AlertError
+
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &verification) ||
+		errors.As(err, &recordHeader) ||
+		errors.As(err, &alert) ||
+		strings.Contains(err.Error(), "tls: ")
+}
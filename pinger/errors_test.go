@@ -0,0 +1,53 @@
+package pinger
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+func TestErrorClasses(t *testing.T) {
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer tlsServer.Close()
+
+	targets := map[string]config.Target{
+		"http_4xx":        config.NewTarget(TestServerBaseURI + "/error/404"),
+		"http_5xx":        config.NewTarget(TestServerBaseURI + "/error/503"),
+		"redirect":        config.NewTarget(TestServerBaseURI + "/error/302"),
+		"timeout":         config.NewTarget(TestServerBaseURI + "/slow"),
+		"body_read_error": config.NewTarget(TestServerBaseURI + "/truncated"),
+		"connect_refused": config.NewTarget("http://" + closed.Addr().String() + "/"),
+		"dns_failure":     config.NewTarget(backendURI("backend.invalid")),
+		"tls_error":       config.NewTarget(tlsServer.URL),
+	}
+
+	timeout := targets["timeout"]
+	timeout.Timeout = 10 * time.Millisecond
+	targets["timeout"] = timeout
+
+	// Nothing listens there, the resolver fails right away
+	dns := targets["dns_failure"]
+	dns.DNSServer = closed.Addr().String()
+	targets["dns_failure"] = dns
+
+	for class, target := range targets {
+		info, err := ping(class, target, "test")
+		if err == nil {
+			t.Errorf("%s: expected an error", class)
+			continue
+		}
+		if info.ErrorClass != class {
+			t.Errorf("%s: got %s for %v", class, info.ErrorClass, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package pinger
+
+import (
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+// pingTarget pings the target once per IP version when it is dual-stack,
+// the results being stored in the order of target.IPVersions
+func pingTarget(name string, target config.Target, userAgent string) *RequestInfo {
+	if !target.IsDualStack() {
+		return pingSamples(name, target, userAgent)
+	}
+
+	families := make([]*RequestInfo, 0, len(target.IPVersions))
+	for _, version := range target.IPVersions {
+		familyTarget := target
+		familyTarget.IPVersions = []int{version}
+		families = append(families, pingSamples(name, familyTarget, userAgent))
+	}
+
+	return aggregateFamilies(families)
+}
+
+// aggregateFamilies returns a RequestInfo holding the timings of the first
+// failed IP version so a broken one is not hidden by the other, or of the
+// first one if all succeeded
+func aggregateFamilies(families []*RequestInfo) *RequestInfo {
+	chosen := families[0]
+	for _, family := range families {
+		if family.Error != nil {
+			chosen = family
+			break
+		}
+	}
+
+	info := newSummary(chosen)
+	info.Error = chosen.Error
+	info.ErrorClass = chosen.ErrorClass
+	info.AssertionFailed = chosen.AssertionFailed
+	info.Hops = chosen.Hops
+	info.Samples = chosen.Samples
+	info.Families = families
+	for _, phase := range AllPhaseNames {
+		info.setPhase(phase, chosen.Phase(phase))
+	}
+
+	return info
+}
@@ -3,6 +3,7 @@ package pinger
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 )
 
 // Requested URIs will be appended in order here
@@ -69,6 +71,12 @@ func TestMain(m *testing.M) {
 // SetupTestServerTest runs an HTTP server for testing with the following routes:
 // - /error/:code to return the HTTP error given by :code
 // - /panic to call panic()
+// - /echo/ to append the method, RequestURI, X-Test header and body to pings
+// - /redirect/:n to redirect :n times before appending the RequestURI to pings
+// - /json to return a small JSON document
+// - /slow to wait for a second before responding
+// - /truncated to send less than the announced Content-Length
+// - /flaky/:n to reset the connection unless it is requested for the :n-th time
 // - anything else to append the RequestURI to the given pings slice
 func SetupTestServer(pings *Pings) (srvCloser io.Closer, port int, err error) {
 	http.HandleFunc("/error/", func(w http.ResponseWriter, req *http.Request) {
@@ -85,6 +93,52 @@ func SetupTestServer(pings *Pings) (srvCloser io.Closer, port int, err error) {
 	http.HandleFunc("/panic", func(w http.ResponseWriter, req *http.Request) {
 		panic("This should be unreachable: " + req.RequestURI)
 	})
+	http.HandleFunc("/echo/", func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		pings.Push(fmt.Sprintf("%s %s %s %s", req.Method, req.RequestURI, req.Header.Get("X-Test"), body))
+	})
+	http.HandleFunc("/redirect/", func(w http.ResponseWriter, req *http.Request) {
+		n, _ := strconv.Atoi(filepath.Base(req.RequestURI))
+		if n <= 0 {
+			pings.Push(req.Method + " " + req.RequestURI)
+			return
+		}
+
+		http.Redirect(w, req, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+	})
+	http.HandleFunc("/json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status": "ok"}`)
+	})
+	http.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(time.Second)
+	})
+	http.HandleFunc("/truncated", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprint(w, "truncated")
+	})
+	flaky := make(map[string]int)
+	var flakyLock sync.Mutex
+	http.HandleFunc("/flaky/", func(w http.ResponseWriter, req *http.Request) {
+		n, _ := strconv.Atoi(filepath.Base(req.RequestURI))
+
+		flakyLock.Lock()
+		flaky[req.RequestURI]++
+		count := flaky[req.RequestURI]
+		flakyLock.Unlock()
+
+		if count == n {
+			return
+		}
+
+		// Reset the connection
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		pings.Push(req.RequestURI)
 	})
@@ -0,0 +1,180 @@
+package pinger
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestInfoJSON is the JSON representation of a RequestInfo, durations
+// are given in microseconds
+type requestInfoJSON struct {
+	Name            string `json:"name"`
+	URI             string `json:"uri"`
+	StatusCode      int    `json:"status_code"`
+	ExpectedStatus  int    `json:"expected_status,omitempty"`
+	AssertionFailed bool   `json:"assertion_failed"`
+	Error           string `json:"error,omitempty"`
+	ErrorClass      string `json:"error_class,omitempty"`
+
+	Start     time.Time            `json:"start"`
+	Durations map[string]int64     `json:"durations_us"`
+	Trace     map[string]time.Time `json:"trace,omitempty"`
+
+	BodySize int `json:"body_size"`
+	Attempts int `json:"attempts,omitempty"`
+
+	Proxy       string `json:"proxy,omitempty"`
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	IPVersion   int    `json:"ip_version,omitempty"`
+	ConnReused  bool   `json:"conn_reused"`
+	ConnWasIdle bool   `json:"conn_was_idle"`
+	Proto       string `json:"proto,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+	TLSVersion  string `json:"tls_version,omitempty"`
+
+	CertExpiry             *time.Time `json:"cert_expiry,omitempty"`
+	IntermediateCertExpiry *time.Time `json:"intermediate_cert_expiry,omitempty"`
+
+	Hops     []*RequestInfo `json:"hops,omitempty"`
+	Samples  []*RequestInfo `json:"samples,omitempty"`
+	Families []*RequestInfo `json:"families,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (t *RequestInfo) MarshalJSON() ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	v := requestInfoJSON{
+		Name:            t.Name,
+		URI:             t.URI,
+		StatusCode:      t.StatusCode,
+		ExpectedStatus:  t.ExpectedStatus,
+		AssertionFailed: t.AssertionFailed,
+		ErrorClass:      t.ErrorClass,
+		Start:           t.start,
+		Durations:       make(map[string]int64, len(PhaseNames)+1),
+		BodySize:        t.BodySize,
+		Attempts:        t.Attempts,
+		Proxy:           t.Proxy,
+		RemoteAddr:      t.RemoteAddr,
+		IPVersion:       t.IPVersion,
+		ConnReused:      t.ConnReused,
+		ConnWasIdle:     t.ConnWasIdle,
+		Proto:           t.Proto,
+		ALPN:            t.ALPN,
+		TLSVersion:      t.TLSVersionName(),
+		Hops:            t.Hops,
+		Samples:         t.Samples,
+		Families:        t.Families,
+	}
+
+	if t.Error != nil {
+		v.Error = strings.TrimSpace(t.Error.Error())
+	}
+
+	for _, phase := range AllPhaseNames {
+		v.Durations[phase] = int64(t.Phase(phase) / time.Microsecond)
+	}
+
+	v.Trace = make(map[string]time.Time, 0)
+	for event, at := range t.traceEvents() {
+		if !at.IsZero() {
+			v.Trace[event] = *at
+		}
+	}
+
+	if !t.CertExpiry.IsZero() {
+		v.CertExpiry = &t.CertExpiry
+	}
+	if !t.IntermediateCertExpiry.IsZero() {
+		v.IntermediateCertExpiry = &t.IntermediateCertExpiry
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *RequestInfo) UnmarshalJSON(data []byte) error {
+	var v requestInfoJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*t = RequestInfo{
+		Name:            v.Name,
+		URI:             v.URI,
+		StatusCode:      v.StatusCode,
+		ExpectedStatus:  v.ExpectedStatus,
+		AssertionFailed: v.AssertionFailed,
+		ErrorClass:      v.ErrorClass,
+		lock:            new(sync.RWMutex),
+		start:           v.Start,
+		BodySize:        v.BodySize,
+		Attempts:        v.Attempts,
+		Proxy:           v.Proxy,
+		RemoteAddr:      v.RemoteAddr,
+		IPVersion:       v.IPVersion,
+		ConnReused:      v.ConnReused,
+		ConnWasIdle:     v.ConnWasIdle,
+		Proto:           v.Proto,
+		ALPN:            v.ALPN,
+		Hops:            v.Hops,
+		Samples:         v.Samples,
+		Families:        v.Families,
+	}
+
+	for version, name := range tlsVersionNames {
+		if name == v.TLSVersion {
+			t.TLSVersion = version
+		}
+	}
+
+	if v.Error != "" {
+		t.Error = errors.New(v.Error)
+	}
+
+	events := t.traceEvents()
+	for event, at := range v.Trace {
+		if ptr, ok := events[event]; ok {
+			*ptr = at
+		}
+	}
+
+	for phase, d := range v.Durations {
+		t.setPhase(phase, time.Duration(d)*time.Microsecond)
+	}
+
+	if v.CertExpiry != nil {
+		t.CertExpiry = *v.CertExpiry
+	}
+	if v.IntermediateCertExpiry != nil {
+		t.IntermediateCertExpiry = *v.IntermediateCertExpiry
+	}
+
+	return nil
+}
+
+// traceEvents returns pointers to the raw trace timestamps indexed by their
+// JSON name
+func (t *RequestInfo) traceEvents() map[string]*time.Time {
+	return map[string]*time.Time{
+		"dns_start":               &t.dnsStart,
+		"dns_done":                &t.dnsDone,
+		"connect_start":           &t.connectStart,
+		"connect_done":            &t.connectDone,
+		"tunnel_done":             &t.tunnelDone,
+		"tls_handshake_start":     &t.tlsHandshakeStart,
+		"tls_handshake_done":      &t.tlsHandshakeDone,
+		"wrote_request":           &t.wroteRequest,
+		"got_first_response_byte": &t.gotFirstResponseByte,
+	}
+}
+
+// Start returns the time at which the request started
+func (t *RequestInfo) Start() time.Time {
+	return t.start
+}
@@ -0,0 +1,79 @@
+package pinger
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	sample := NewRequestInfo()
+	sample.Name = "example"
+	sample.Total = 1500 * time.Microsecond
+
+	info := NewRequestInfo()
+	info.RequestStart("example", "https://example.com/")
+	info.DNSStart()
+	info.DNSDone()
+	info.Resolving = 2 * time.Millisecond
+	info.StatusCode = 503
+	info.Error = errors.New("Got a 503, unable to fetch https://example.com/\n")
+	info.TLSHandshake = 3 * time.Millisecond
+	info.Total = 10 * time.Millisecond
+	info.BodySize = 42
+	info.CertExpiry = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	info.Samples = []*RequestInfo{sample}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &RequestInfo{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Name != info.Name || decoded.URI != info.URI || decoded.StatusCode != 503 || decoded.BodySize != 42 {
+		t.Errorf("Unexpected decoded RequestInfo: %+v", decoded)
+	}
+	if decoded.Error == nil || decoded.Error.Error() != "Got a 503, unable to fetch https://example.com/" {
+		t.Error("Unexpected decoded error: ", decoded.Error)
+	}
+	if decoded.TLSHandshake != info.TLSHandshake || decoded.Total != info.Total || decoded.Resolving != info.Resolving {
+		t.Error("Unexpected decoded durations: ", decoded.TLSHandshake, decoded.Total)
+	}
+	if !decoded.Start().Equal(info.Start()) || !decoded.CertExpiry.Equal(info.CertExpiry) {
+		t.Error("Unexpected decoded times: ", decoded.Start(), decoded.CertExpiry)
+	}
+	if !decoded.dnsDone.Equal(info.dnsDone) || !decoded.connectDone.IsZero() {
+		t.Error("Unexpected decoded trace: ", decoded.dnsDone, decoded.connectDone)
+	}
+	if !decoded.IntermediateCertExpiry.IsZero() {
+		t.Error("Expected no intermediate certificate expiry.")
+	}
+	if len(decoded.Samples) != 1 || decoded.Samples[0].Total != sample.Total {
+		t.Error("Unexpected decoded samples: ", decoded.Samples)
+	}
+
+	// Decoded RequestInfo must be usable
+	decoded.Lock()
+	decoded.Unlock()
+
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	if trace := raw["trace"].(map[string]interface{}); len(trace) != 2 {
+		t.Error("Expected only the DNS trace timestamps, got ", trace)
+	}
+
+	expected := map[string]interface{}{
+		"redirecting": 0.0, "resolving": 2000.0, "connecting": 0.0, "proxy_connect": 0.0,
+		"tunnel_established": 0.0, "tls": 3000.0, "sending": 0.0, "waiting": 0.0,
+		"receiving": 0.0, "total": 10000.0,
+	}
+	if !reflect.DeepEqual(raw["durations_us"], expected) {
+		t.Error("Unexpected durations: ", raw["durations_us"])
+	}
+}
@@ -1,11 +1,16 @@
 package pinger
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptrace"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/DigitalBackstage/munin-http-timing/config"
@@ -13,61 +18,224 @@ import (
 
 const httpGetTimeout = time.Duration(20 * time.Second)
 
-// ping performs an HTTP request and returns the timing information
-// If the request completes but fails (redirection or any error 4XX/5XX error)
-// the correct timing information will be returned along with an error message.
-func ping(name, uri, userAgent string) (*RequestInfo, error) {
+const defaultRetryBackoff = time.Second
+
+func init() {
+	// Spreads the requests of DoParallelPings
+	rand.Seed(time.Now().Unix())
+}
+
+// ping performs an HTTP request, retrying up to target.Retries times on
+// transient failures, and returns the timing information of the last attempt
+// The delay between two attempts starts at target.RetryBackoff and doubles
+// after each one, retries stop once the attempts and delays would take longer
+// than the target timeout.
+func ping(name string, target config.Target, userAgent string) (*RequestInfo, error) {
+	backoff := target.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	begin := time.Now()
+	for attempt := 1; ; attempt++ {
+		info, err := pingAttempt(name, target, userAgent)
+		info.Attempts = attempt
+		if err != nil {
+			info.ErrorClass = classifyError(err, info.StatusCode)
+		}
+		if err == nil || attempt > target.Retries || !isTransient(err, info.ErrorClass) || time.Since(begin)+backoff > getTimeout(target) {
+			return info, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// pingAttempt performs an HTTP request and returns the timing information
+// If the request completes but fails (redirection, any error 4XX/5XX error or
+// unexpected status) the correct timing information will be returned along
+// with an error message.
+// Up to target.FollowRedirects redirections are followed, the timings of each
+// hop but the last one are stored in the returned RequestInfo Hops.
+// A new connection is opened so all the phases are measured, only the hops
+// to the same host may reuse it.
+func pingAttempt(name string, target config.Target, userAgent string) (*RequestInfo, error) {
+	method, uri, body := target.Method, target.URI, target.Body
+	hops := make([]*RequestInfo, 0)
+
+	transport, err := getTransport(target)
+	if err != nil {
+		// Still report the failure under the target name
+		info := NewRequestInfo()
+		info.ExpectedStatus = target.ExpectStatus
+		info.RequestStart(name, target.RedactedURI())
+		return info, err
+	}
+	transport = transport.Clone()
+	defer transport.CloseIdleConnections()
+
+	for {
+		info, location, responseBody, err := pingHop(name, target, transport, method, uri, body, userAgent)
+		if err != nil || location == nil || len(hops) >= target.FollowRedirects {
+			if err == nil {
+				err = checkStatus(info, target.ExpectStatus, config.RedactURI(uri))
+			}
+			if err == nil {
+				err = checkAssertions(responseBody, target, config.RedactURI(uri))
+				info.AssertionFailed = err != nil
+			}
+
+			info.SetHops(hops)
+			info.URI = target.RedactedURI()
+			return info, err
+		}
+
+		hops = append(hops, info)
+		uri = location.String()
+
+		// Follow the same rules as http.Client regarding the method and body
+		if info.StatusCode != http.StatusTemporaryRedirect && info.StatusCode != http.StatusPermanentRedirect {
+			if method != http.MethodHead {
+				method = http.MethodGet
+			}
+			body = ""
+		}
+	}
+}
+
+// pingHop performs a single HTTP request without following redirections and
+// returns the timing information along with the redirection location if any
+// and the response body
+func pingHop(name string, target config.Target, transport *http.Transport, method, uri, body, userAgent string) (*RequestInfo, *url.URL, []byte, error) {
 	var err error
 
 	info := NewRequestInfo()
+	info.ExpectedStatus = target.ExpectStatus
+
 	trace := getHTTPTrace(info)
 	client := http.Client{
-		Timeout: httpGetTimeout,
+		Transport: transport,
+		Timeout:   getTimeout(target),
 		// Disable redirect, https://stackoverflow.com/a/38150816
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
-	req, err := http.NewRequest("GET", uri, nil)
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, uri, reqBody)
 	if err != nil {
-		return info, err
+		// The parse error holds the whole URI, password included
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		// Still report the failure under the target name
+		info.RequestStart(name, config.RedactURI(uri))
+		return info, nil, nil, fmt.Errorf("Invalid URI for %s: %s\n", name, err)
 	}
 	req.Header.Set("User-Agent", userAgent)
+	sendSecrets := isSameHost(req.URL, target.URI)
+	for key, values := range target.Headers {
+		if sendSecrets || !isSecretHeader(key, target) {
+			req.Header[key] = values
+		}
+	}
+	if host := target.Headers.Get("Host"); host != "" && uri == target.URI {
+		req.Host = host
+	}
 
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &trace))
+	ctx := context.WithValue(req.Context(), requestInfoKey{}, info)
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &trace))
+	info.SetProxy(getProxy(transport, req))
 
-	info.RequestStart(name, uri)
+	info.RequestStart(name, config.RedactURI(uri))
 	response, err := client.Do(req)
 	if response != nil {
 		defer response.Body.Close()
 	}
 
 	if err != nil {
-		return info, err
+		return info, nil, nil, err
 	}
 
-	info.BodySize, err = getResponseBodyBodySize(response)
+	info.SetResponse(response.Proto, response.TLS)
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	info.BodySize = len(responseBody)
 	if err != nil {
-		return info, err
+		return info, nil, nil, bodyReadError{err}
 	}
 
 	// Keep this _after_ fetching the whole body because Request.Do returns as
 	// soon as the headers are received.
 	info.RequestDone(response.StatusCode)
 
-	if info.StatusCode >= 400 {
-		err = fmt.Errorf("Got a %d, unable to fetch %s\n", info.StatusCode, uri)
-	} else if info.StatusCode >= 300 && info.StatusCode < 400 {
-		err = fmt.Errorf("Not following %d redirection given by %s\n", info.StatusCode, uri)
+	location, err := response.Location()
+	if err != nil || info.StatusCode < 300 || info.StatusCode >= 400 {
+		location = nil
+	}
+
+	return info, location, responseBody, nil
+}
+
+// getTimeout returns the time after which a request to the target is aborted
+func getTimeout(target config.Target) time.Duration {
+	if target.Timeout <= 0 {
+		return httpGetTimeout
 	}
 
-	return info, err
+	return target.Timeout
 }
 
-func getResponseBodyBodySize(r *http.Response) (int, error) {
-	body, err := ioutil.ReadAll(r.Body)
-	return len(body), err
+// isSameHost returns true if the request goes to the host of the target URI
+// or one of its subdomains, like http.Client when following redirections
+func isSameHost(reqURL *url.URL, targetURI string) bool {
+	targetURL, err := url.Parse(targetURI)
+	if err != nil {
+		return false
+	}
+
+	host, targetHost := strings.ToLower(reqURL.Hostname()), strings.ToLower(targetURL.Hostname())
+	return host == targetHost || strings.HasSuffix(host, "."+targetHost)
+}
+
+// isSecretHeader returns true if the header holds credentials not to be sent
+// to another host than the target one
+func isSecretHeader(key string, target config.Target) bool {
+	switch http.CanonicalHeaderKey(key) {
+	case "Authorization", "Www-Authenticate", "Cookie", "Cookie2":
+		return true
+	}
+
+	for _, secret := range target.SecretHeaders {
+		if http.CanonicalHeaderKey(key) == secret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkStatus returns an error if the status code of the final response is
+// not the expected one or an error/redirection when none is expected
+func checkStatus(info *RequestInfo, expectStatus int, uri string) error {
+	switch {
+	case expectStatus != 0:
+		if info.StatusCode != expectStatus {
+			return fmt.Errorf("Got a %d instead of %d, unable to fetch %s\n", info.StatusCode, expectStatus, uri)
+		}
+	case info.StatusCode >= 400:
+		return fmt.Errorf("Got a %d, unable to fetch %s\n", info.StatusCode, uri)
+	case info.StatusCode >= 300 && info.StatusCode < 400:
+		return fmt.Errorf("Not following %d redirection given by %s\n", info.StatusCode, uri)
+	}
+
+	return nil
 }
 
 func getHTTPTrace(info *RequestInfo) httptrace.ClientTrace {
@@ -78,8 +246,20 @@ func getHTTPTrace(info *RequestInfo) httptrace.ClientTrace {
 		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
 			info.DNSDone()
 		},
+		ConnectStart: func(network, addr string) {
+			info.ConnectStart()
+		},
 		ConnectDone: func(network, addr string, err error) {
-			info.ConnectDone()
+			info.ConnectDone(addr, err)
+		},
+		GotConn: func(connInfo httptrace.GotConnInfo) {
+			info.GotConn(connInfo.Conn.RemoteAddr().String(), connInfo.Reused, connInfo.WasIdle)
+		},
+		TLSHandshakeStart: func() {
+			info.TLSHandshakeStart()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			info.TLSHandshakeDone()
 		},
 		WroteRequest: func(wr httptrace.WroteRequestInfo) {
 			info.WroteRequest()
@@ -90,19 +270,34 @@ func getHTTPTrace(info *RequestInfo) httptrace.ClientTrace {
 	}
 }
 
-// DoParallelPings calls ping on the given URIs and pushes the result in the
+// Ping pings a single target, taking its samples, and returns the result
+func Ping(name string, target config.Target, userAgent string) *RequestInfo {
+	return pingTarget(name, target, userAgent)
+}
+
+// PingAll pings all the targets in parallel and returns the results
+func PingAll(config config.Config) []*RequestInfo {
+	requests := make([]*RequestInfo, 0, len(config.Targets))
+	queue := make(chan *RequestInfo, len(config.Targets))
+	DoParallelPings(config, queue)
+	for i := 0; i < len(config.Targets); i++ {
+		requests = append(requests, <-queue)
+	}
+
+	return requests
+}
+
+// DoParallelPings calls ping on the given targets and pushes the result in the
 // given queue
 func DoParallelPings(config config.Config, queue chan<- *RequestInfo) {
-	for name, uri := range config.URIs {
-		go func(name, uri string) {
+	for name := range config.Targets {
+		go func(name string) {
 			// Avoid sending all requests at the exact same time
 			if config.RandomDelayEnabled {
 				time.Sleep(time.Duration(rand.Intn(2000)) * time.Millisecond)
 			}
 
-			info, err := ping(name, uri, config.UserAgent)
-			info.Error = err
-			queue <- info
-		}(name, uri)
+			queue <- pingTarget(name, config.Targets[name], config.UserAgent)
+		}(name)
 	}
 }
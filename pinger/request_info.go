@@ -1,6 +1,10 @@
 package pinger
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -8,30 +12,91 @@ import (
 // RequestInfo contains the different timings involved in sending
 // an HTTP request and its response
 type RequestInfo struct {
-	Name       string
-	URI        string
-	StatusCode int
-	Error      error
+	Name            string
+	URI             string
+	StatusCode      int
+	ExpectedStatus  int
+	AssertionFailed bool
+	Error           error
+
+	// One of ErrorClasses when Error is set
+	ErrorClass string
 
 	lock *sync.RWMutex
 
 	start                time.Time
 	dnsStart             time.Time
 	dnsDone              time.Time
+	connectStart         time.Time
 	connectDone          time.Time
+	tunnelDone           time.Time
+	tlsHandshakeStart    time.Time
+	tlsHandshakeDone     time.Time
 	wroteRequest         time.Time
 	gotFirstResponseByte time.Time
 
-	Resolving  time.Duration
-	Connecting time.Duration
-	Sending    time.Duration
-	Waiting    time.Duration
-	Receiving  time.Duration
-	Total      time.Duration
+	Redirecting  time.Duration
+	Resolving    time.Duration
+	Connecting   time.Duration
+	TLSHandshake time.Duration
+	Sending      time.Duration
+	Waiting      time.Duration
+	Receiving    time.Duration
+	Total        time.Duration
+
+	// Replace Connecting when going through a proxy: connection to the proxy
+	// then CONNECT request establishing the tunnel to the target
+	ProxyConnect      time.Duration
+	TunnelEstablished time.Duration
 
 	BodySize int
+
+	// Number of attempts made, more than one when retrying after failures
+	Attempts int
+
+	// Connection details of the final request, IPVersion is 4 or 6 and stays
+	// zero when the remote address is unknown
+	Proxy       string
+	RemoteAddr  string
+	IPVersion   int
+	ConnReused  bool
+	ConnWasIdle bool
+	Proto       string
+	ALPN        string
+	TLSVersion  uint16
+
+	// Expiration dates of the peer leaf certificate and of the earliest
+	// expiring intermediate certificate, zero if none was received
+	CertExpiry             time.Time
+	IntermediateCertExpiry time.Time
+
+	// Redirections followed before the final request, in order
+	Hops []*RequestInfo
+
+	// Individual requests when more than one sample was taken
+	Samples []*RequestInfo
+
+	// Results of each IP version when probing a dual-stack target, in the
+	// order of the target IPVersions
+	Families []*RequestInfo
+}
+
+// PhaseNames lists the request phases in chronological order
+var PhaseNames = []string{
+	"redirecting",
+	"resolving",
+	"connecting",
+	"proxy_connect",
+	"tunnel_established",
+	"tls",
+	"sending",
+	"waiting",
+	"receiving",
 }
 
+// AllPhaseNames lists the request phases followed by the total
+var AllPhaseNames = append(append([]string{}, PhaseNames...), "total")
+
 // NewRequestInfo creates a new RequestInfo
 func NewRequestInfo() *RequestInfo {
 	r := &RequestInfo{}
@@ -42,9 +107,73 @@ func NewRequestInfo() *RequestInfo {
 
 // IsOk returns true if the request succeeded
 func (t *RequestInfo) IsOk() bool {
+	// No status code means no response was received at all
+	if t.AssertionFailed || t.StatusCode == 0 {
+		return false
+	}
+
+	if t.ExpectedStatus != 0 {
+		return t.StatusCode == t.ExpectedStatus
+	}
+
 	return t.StatusCode < 400
 }
 
+// Phase returns the duration of the phase given by its name, "total" being
+// the duration of the whole request
+func (t *RequestInfo) Phase(name string) time.Duration {
+	switch name {
+	case "redirecting":
+		return t.Redirecting
+	case "resolving":
+		return t.Resolving
+	case "connecting":
+		return t.Connecting
+	case "proxy_connect":
+		return t.ProxyConnect
+	case "tunnel_established":
+		return t.TunnelEstablished
+	case "tls":
+		return t.TLSHandshake
+	case "sending":
+		return t.Sending
+	case "waiting":
+		return t.Waiting
+	case "receiving":
+		return t.Receiving
+	case "total":
+		return t.Total
+	}
+
+	return 0
+}
+
+// setPhase sets the duration of the phase given by its name
+func (t *RequestInfo) setPhase(name string, d time.Duration) {
+	switch name {
+	case "redirecting":
+		t.Redirecting = d
+	case "resolving":
+		t.Resolving = d
+	case "connecting":
+		t.Connecting = d
+	case "proxy_connect":
+		t.ProxyConnect = d
+	case "tunnel_established":
+		t.TunnelEstablished = d
+	case "tls":
+		t.TLSHandshake = d
+	case "sending":
+		t.Sending = d
+	case "waiting":
+		t.Waiting = d
+	case "receiving":
+		t.Receiving = d
+	case "total":
+		t.Total = d
+	}
+}
+
 // RequestStart starts the timer
 func (t *RequestInfo) RequestStart(name, uri string) {
 	t.lock.Lock()
@@ -65,18 +194,58 @@ func (t *RequestInfo) Unlock() {
 	t.lock.Unlock()
 }
 
-// ConnectDone sets the connection time
-func (t *RequestInfo) ConnectDone() {
+// ConnectStart sets the time the first connection attempt started
+func (t *RequestInfo) ConnectStart() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.connectStart.IsZero() {
+		t.connectStart = time.Now()
+	}
+}
+
+// ConnectDone sets the connection time and the address connected to, failed
+// attempts (eg. IPv6 before falling back to IPv4) are ignored
+func (t *RequestInfo) ConnectDone(addr string, err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
+	if err != nil {
+		return
+	}
+
 	t.connectDone = time.Now()
+	t.setRemoteAddr(addr)
 
 	// If there was no DNS request (eg. IP), use start time
-	if t.dnsDone.IsZero() {
-		t.Connecting = t.connectDone.Sub(t.start)
+	connecting := t.connectDone.Sub(t.start)
+	if !t.dnsDone.IsZero() {
+		connecting = t.connectDone.Sub(t.dnsDone)
+	}
+
+	if t.Proxy != "" {
+		t.ProxyConnect = connecting
 	} else {
-		t.Connecting = t.connectDone.Sub(t.dnsDone)
+		t.Connecting = connecting
+	}
+}
+
+// SetProxy records the address of the proxy the request goes through
+func (t *RequestInfo) SetProxy(proxy string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.Proxy = proxy
+}
+
+// TunnelDone sets the time the proxy answered the CONNECT request
+func (t *RequestInfo) TunnelDone() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.tunnelDone = time.Now()
+	if !t.connectDone.IsZero() {
+		t.TunnelEstablished = t.tunnelDone.Sub(t.connectDone)
 	}
 }
 
@@ -88,13 +257,33 @@ func (t *RequestInfo) WroteRequest() {
 	t.wroteRequest = time.Now()
 
 	// If there was no connection (eg. hitting the same server twice), use start time
-	if t.connectDone.IsZero() {
-		t.Sending = t.wroteRequest.Sub(t.start)
-	} else {
+	switch {
+	case !t.tlsHandshakeDone.IsZero():
+		t.Sending = t.wroteRequest.Sub(t.tlsHandshakeDone)
+	case !t.connectDone.IsZero():
 		t.Sending = t.wroteRequest.Sub(t.connectDone)
+	default:
+		t.Sending = t.wroteRequest.Sub(t.start)
 	}
 }
 
+// TLSHandshakeStart starts the TLS handshake timer
+func (t *RequestInfo) TLSHandshakeStart() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.tlsHandshakeStart = time.Now()
+}
+
+// TLSHandshakeDone sets the TLS handshake time
+func (t *RequestInfo) TLSHandshakeDone() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.tlsHandshakeDone = time.Now()
+	t.TLSHandshake = t.tlsHandshakeDone.Sub(t.tlsHandshakeStart)
+}
+
 // GotFirstResponseByte sets the waiting time
 func (t *RequestInfo) GotFirstResponseByte() {
 	t.lock.Lock()
@@ -114,6 +303,150 @@ func (t *RequestInfo) RequestDone(statusCode int) {
 	t.StatusCode = statusCode
 }
 
+// FailedAssertions returns the number of requests whose response body did not
+// satisfy the assertions, samples included
+func (t *RequestInfo) FailedAssertions() int {
+	if len(t.Samples) == 0 {
+		if t.AssertionFailed {
+			return 1
+		}
+		return 0
+	}
+
+	failed := 0
+	for _, sample := range t.Samples {
+		if sample.AssertionFailed {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// GotConn records the address of the server the request is sent to and
+// whether the connection was reused from a previous request
+func (t *RequestInfo) GotConn(remoteAddr string, reused, wasIdle bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.setRemoteAddr(remoteAddr)
+	t.ConnReused = reused
+	t.ConnWasIdle = wasIdle
+}
+
+// setRemoteAddr records the remote address and the IP version it uses
+func (t *RequestInfo) setRemoteAddr(addr string) {
+	t.RemoteAddr = addr
+	t.IPVersion = 0
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			t.IPVersion = 4
+		} else {
+			t.IPVersion = 6
+		}
+	}
+}
+
+// SetResponse records the protocol and TLS details of the response
+func (t *RequestInfo) SetResponse(proto string, state *tls.ConnectionState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.Proto = proto
+	if state == nil {
+		return
+	}
+
+	t.TLSVersion = state.Version
+	t.ALPN = state.NegotiatedProtocol
+	chain := state.PeerCertificates
+	if len(chain) == 0 {
+		return
+	}
+
+	t.CertExpiry = chain[0].NotAfter
+	for _, cert := range chain[1:] {
+		if t.IntermediateCertExpiry.IsZero() || cert.NotAfter.Before(t.IntermediateCertExpiry) {
+			t.IntermediateCertExpiry = cert.NotAfter
+		}
+	}
+}
+
+// Retries returns the number of failed attempts before the last one
+func (t *RequestInfo) Retries() int {
+	if t.Attempts <= 1 {
+		return 0
+	}
+
+	return t.Attempts - 1
+}
+
+// ConnectionSummary describes the connection used by the request, eg.
+// "127.0.0.1:80 (IPv4, reused idle connection)", empty if no connection was
+// made
+func (t *RequestInfo) ConnectionSummary() string {
+	if t.RemoteAddr == "" {
+		return ""
+	}
+
+	details := make([]string, 0, 2)
+	if t.IPVersion != 0 {
+		details = append(details, fmt.Sprintf("IPv%d", t.IPVersion))
+	}
+	switch {
+	case t.ConnReused && t.ConnWasIdle:
+		details = append(details, "reused idle connection")
+	case t.ConnReused:
+		details = append(details, "reused connection")
+	default:
+		details = append(details, "new connection")
+	}
+
+	return fmt.Sprintf("%s (%s)", t.RemoteAddr, strings.Join(details, ", "))
+}
+
+// tlsVersionNames maps TLS versions to their name
+var tlsVersionNames = map[uint16]string{
+	tls.VersionSSL30: "SSL 3.0",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// TLSVersionName returns the name of the negotiated TLS version, empty if
+// TLS was not used
+func (t *RequestInfo) TLSVersionName() string {
+	if t.TLSVersion == 0 {
+		return ""
+	}
+
+	if name, ok := tlsVersionNames[t.TLSVersion]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%04x", t.TLSVersion)
+}
+
+// SetHops stores the redirections followed before this request, their total
+// time is added to the redirecting and total times
+func (t *RequestInfo) SetHops(hops []*RequestInfo) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.Hops = hops
+	for _, hop := range hops {
+		t.Redirecting += hop.Total
+		t.Total += hop.Total
+	}
+}
+
 // DNSStart starts the resolution timer
 func (t *RequestInfo) DNSStart() {
 	t.lock.Lock()
@@ -0,0 +1,137 @@
+package pinger
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+// pingSamples calls ping target.Samples times in a row and returns the
+// requested percentile of each phase
+// Failed samples are ignored, if all of them failed the last one is returned.
+func pingSamples(name string, target config.Target, userAgent string) *RequestInfo {
+	if target.Samples <= 1 {
+		info, err := ping(name, target, userAgent)
+		info.Error = err
+		return info
+	}
+
+	samples := make([]*RequestInfo, 0, target.Samples)
+	for i := 0; i < target.Samples; i++ {
+		info, err := ping(name, target, userAgent)
+		info.Error = err
+		samples = append(samples, info)
+	}
+
+	return aggregateSamples(samples, target.Percentile)
+}
+
+// aggregateSamples returns a RequestInfo holding the given percentile of
+// each phase of the successful samples
+func aggregateSamples(samples []*RequestInfo, percentile float64) *RequestInfo {
+	succeeded := make([]*RequestInfo, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Error == nil {
+			succeeded = append(succeeded, sample)
+		}
+	}
+
+	if len(succeeded) == 0 {
+		last := samples[len(samples)-1]
+		last.Samples = samples
+		return last
+	}
+
+	info := newSummary(succeeded[len(succeeded)-1])
+	info.Samples = samples
+
+	// Report the flakiest sample
+	for _, sample := range samples {
+		if sample.Attempts > info.Attempts {
+			info.Attempts = sample.Attempts
+		}
+	}
+	info.start = samples[0].start
+
+	for _, phase := range AllPhaseNames {
+		values := make([]time.Duration, 0, len(succeeded))
+		for _, sample := range succeeded {
+			values = append(values, sample.Phase(phase))
+		}
+
+		info.setPhase(phase, percentileOf(values, percentile))
+	}
+
+	return info
+}
+
+// newSummary returns a new RequestInfo holding the response and connection
+// details of the given one, but none of its timings
+func newSummary(from *RequestInfo) *RequestInfo {
+	info := NewRequestInfo()
+	info.Name = from.Name
+	info.URI = from.URI
+	info.StatusCode = from.StatusCode
+	info.ExpectedStatus = from.ExpectedStatus
+	info.BodySize = from.BodySize
+	info.Attempts = from.Attempts
+	info.Proxy = from.Proxy
+	info.RemoteAddr = from.RemoteAddr
+	info.IPVersion = from.IPVersion
+	info.ConnReused = from.ConnReused
+	info.ConnWasIdle = from.ConnWasIdle
+	info.Proto = from.Proto
+	info.ALPN = from.ALPN
+	info.TLSVersion = from.TLSVersion
+	info.CertExpiry = from.CertExpiry
+	info.IntermediateCertExpiry = from.IntermediateCertExpiry
+	info.start = from.start
+
+	return info
+}
+
+// percentileOf returns the given percentile of the values using the
+// nearest-rank method, 0 if there are no values
+func percentileOf(values []time.Duration, percentile float64) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	if percentile <= 0 {
+		percentile = 50
+	}
+
+	sorted := append([]time.Duration{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// TotalRange returns the minimum and maximum total time of the successful
+// samples, ok is false if there are none
+func (t *RequestInfo) TotalRange() (min, max time.Duration, ok bool) {
+	if len(t.Samples) == 0 {
+		return t.Total, t.Total, t.IsOk()
+	}
+
+	for _, sample := range t.Samples {
+		if sample.Error != nil {
+			continue
+		}
+		if !ok || sample.Total < min {
+			min = sample.Total
+		}
+		if !ok || sample.Total > max {
+			max = sample.Total
+		}
+		ok = true
+	}
+
+	return
+}
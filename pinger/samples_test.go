@@ -0,0 +1,105 @@
+package pinger
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+func TestPercentileOf(t *testing.T) {
+	values := []time.Duration{5, 1, 4, 2, 3}
+
+	cases := map[float64]time.Duration{50: 3, 90: 5, 100: 5, 1: 1, 40: 2}
+	for percentile, expected := range cases {
+		if actual := percentileOf(values, percentile); actual != expected {
+			t.Errorf("Expected percentile %v to be %v, got %v", percentile, expected, actual)
+		}
+	}
+
+	if !reflect.DeepEqual(values, []time.Duration{5, 1, 4, 2, 3}) {
+		t.Error("percentileOf should not modify its input.")
+	}
+	if actual := percentileOf(nil, 50); actual != 0 {
+		t.Error("Expected 0 without values, got ", actual)
+	}
+}
+
+func TestAggregateSamples(t *testing.T) {
+	samples := make([]*RequestInfo, 0)
+	for _, total := range []time.Duration{30, 10, 1000, 20} {
+		info := NewRequestInfo()
+		info.Name = "example"
+		info.StatusCode = 200
+		info.Total = total
+		info.Waiting = total / 2
+		samples = append(samples, info)
+	}
+	samples[2].Error = errors.New("Got a 500")
+
+	info := aggregateSamples(samples, 50)
+	if info.Total != 20 || info.Waiting != 10 {
+		t.Errorf("Expected the median of successful samples, got %v/%v", info.Total, info.Waiting)
+	}
+	if info.Error != nil {
+		t.Error("Unexpected error: ", info.Error)
+	}
+
+	min, max, ok := info.TotalRange()
+	if !ok || min != 10 || max != 30 {
+		t.Errorf("Unexpected range: %v %v %v", min, max, ok)
+	}
+
+	for _, sample := range samples {
+		sample.Error = errors.New("Got a 500")
+	}
+	if info = aggregateSamples(samples, 50); info.Error == nil {
+		t.Error("Expected an error when all samples failed.")
+	}
+	if _, _, ok = info.TotalRange(); ok {
+		t.Error("Expected no range when all samples failed.")
+	}
+}
+
+func TestPingSamples(t *testing.T) {
+	TestServerPings.Purge()
+
+	target := config.NewTarget(TestServerBaseURI + "/samples")
+	target.Samples = 3
+	info := pingSamples("samples", target, "test")
+
+	if info.Error != nil {
+		t.Error(info.Error)
+	}
+	if len(info.Samples) != 3 {
+		t.Error("Expected 3 samples, got ", len(info.Samples))
+	}
+
+	expected := []string{"/samples", "/samples", "/samples"}
+	if actual := TestServerPings.Sorted(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected 3 requests, got %v", actual)
+	}
+}
+
+func TestPingSamplesFreshConnections(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	target := config.NewTarget(srv.URL)
+	target.InsecureSkipVerify = true
+	target.Samples = 5
+	info := pingSamples("samples", target, "test")
+
+	if info.Error != nil {
+		t.Fatal(info.Error)
+	}
+	for i, sample := range info.Samples {
+		if sample.ConnReused || sample.Connecting <= 0 || sample.TLSHandshake <= 0 {
+			t.Errorf("Expected sample %d to open a new connection, got %v connecting and %v tls", i, sample.Connecting, sample.TLSHandshake)
+		}
+	}
+}
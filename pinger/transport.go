@@ -0,0 +1,248 @@
+package pinger
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+// transportKey holds the target options requiring a dedicated transport
+type transportKey struct {
+	ipVersion int
+	resolve   string
+	dnsServer string
+
+	caFile             string
+	clientCert         string
+	clientKey          string
+	serverName         string
+	insecureSkipVerify bool
+
+	protocol string
+	proxy    string
+}
+
+// cachedTransport is a transport along with the modification time of its
+// TLS files when it was created
+type cachedTransport struct {
+	transport *http.Transport
+	modTime   time.Time
+}
+
+// transports caches one transport per set of options, the default one
+// included
+var transports = struct {
+	sync.Mutex
+	byKey map[transportKey]cachedTransport
+}{byKey: make(map[transportKey]cachedTransport)}
+
+// getTransport returns the transport to ping the target with, an error is
+// returned if its TLS files cannot be loaded
+// The transport is created again when its TLS files change so renewed
+// certificates are used without restarting the daemon.
+// It is shared by all the pings, see pingAttempt for its connections.
+func getTransport(target config.Target) (*http.Transport, error) {
+	key := transportKey{
+		resolve:            target.Resolve,
+		dnsServer:          target.DNSServer,
+		caFile:             target.CAFile,
+		clientCert:         target.ClientCert,
+		clientKey:          target.ClientKey,
+		serverName:         target.ServerName,
+		insecureSkipVerify: target.InsecureSkipVerify,
+		protocol:           target.Protocol,
+		proxy:              target.Proxy,
+	}
+	if len(target.IPVersions) == 1 {
+		key.ipVersion = target.IPVersions[0]
+	}
+
+	transports.Lock()
+	defer transports.Unlock()
+
+	modTime := tlsFilesModTime(key)
+	if cached, ok := transports.byKey[key]; ok && cached.modTime.Equal(modTime) {
+		return cached.transport, nil
+	}
+
+	transport, err := newTransport(key)
+	if err != nil {
+		return nil, err
+	}
+	transports.byKey[key] = cachedTransport{transport, modTime}
+
+	return transport, nil
+}
+
+// tlsFilesModTime returns the latest modification time of the TLS files,
+// missing ones being ignored
+func tlsFilesModTime(key transportKey) time.Time {
+	var latest time.Time
+	for _, path := range []string{key.caFile, key.clientCert, key.clientKey} {
+		if path == "" {
+			continue
+		}
+		if stat, err := os.Stat(path); err == nil && stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+
+	return latest
+}
+
+// newTransport returns a copy of http.DefaultTransport dialing with the given
+// options
+func newTransport(key transportKey) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	// The DNS events are still traced when using a custom resolver
+	if key.dnsServer != "" {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, key.dnsServer)
+			},
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(key)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Protocols = newProtocols(key.protocol)
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// Don't fall back to the other IP version
+		if key.ipVersion != 0 {
+			network = fmt.Sprintf("tcp%d", key.ipVersion)
+		}
+
+		// Skip DNS, the URI host is still used for SNI and the Host header
+		if key.resolve != "" {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			addr = net.JoinHostPort(key.resolve, port)
+		}
+
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	// The proxy is given by HTTPS_PROXY and the like unless set on the target
+	if key.proxy != "" {
+		proxyURL, err := url.Parse(key.proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Going through a proxy would defeat the resolution override
+	if key.resolve != "" {
+		transport.Proxy = nil
+	}
+
+	transport.OnProxyConnectResponse = func(ctx context.Context, _ *url.URL, _ *http.Request, _ *http.Response) error {
+		if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+			info.TunnelDone()
+		}
+		return nil
+	}
+
+	return transport, nil
+}
+
+// requestInfoKey is the context key of the RequestInfo of a request, for
+// the transport hooks without an httptrace equivalent
+type requestInfoKey struct{}
+
+// getProxy returns the host of the proxy the request goes through, empty if
+// none
+func getProxy(transport *http.Transport, req *http.Request) string {
+	if transport.Proxy == nil {
+		return ""
+	}
+
+	proxyURL, err := transport.Proxy(req)
+	if err != nil || proxyURL == nil {
+		return ""
+	}
+
+	return proxyURL.Host
+}
+
+// newProtocols returns the protocols the transport may use, nil for the
+// default ones
+func newProtocols(protocol string) *http.Protocols {
+	protocols := new(http.Protocols)
+	switch protocol {
+	case "http1":
+		protocols.SetHTTP1(true)
+	case "h2":
+		protocols.SetHTTP2(true)
+	case "h2c":
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil
+	}
+
+	return protocols
+}
+
+// newTLSConfig returns the TLS configuration for the given options, nil to
+// use the default one
+func newTLSConfig(key transportKey) (*tls.Config, error) {
+	if key.caFile == "" && key.clientCert == "" && key.serverName == "" && !key.insecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         key.serverName,
+		InsecureSkipVerify: key.insecureSkipVerify,
+	}
+
+	if key.caFile != "" {
+		pem, err := ioutil.ReadFile(key.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the CA file: %s\n", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No PEM certificate found in %s\n", key.caFile)
+		}
+	}
+
+	if key.clientCert != "" {
+		// The key may be in the same file as the certificate
+		keyFile := key.clientKey
+		if keyFile == "" {
+			keyFile = key.clientCert
+		}
+
+		cert, err := tls.LoadX509KeyPair(key.clientCert, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the client certificate: %s\n", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,309 @@
+package pinger
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+)
+
+// startDNSServer starts a DNS server answering 127.0.0.1 to every A query and
+// nothing to the other ones, it returns its address
+func startDNSServer(t *testing.T) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+
+			// Header then the single question: name, type and class
+			end := 12
+			for end < n && buf[end] != 0 {
+				end += int(buf[end]) + 1
+			}
+			end += 5
+			if end > n {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(buf[end-4 : end-2])
+
+			resp := append([]byte{}, buf[:end]...)
+			resp[2], resp[3] = 0x81, 0x80           // Response, recursion available
+			binary.BigEndian.PutUint16(resp[6:], 0) // No answer
+			binary.BigEndian.PutUint16(resp[8:], 0)
+			binary.BigEndian.PutUint16(resp[10:], 0)
+			if qtype == 1 {
+				binary.BigEndian.PutUint16(resp[6:], 1)
+				resp = append(resp,
+					0xc0, 0x0c, // Pointer to the question name
+					0x00, 0x01, 0x00, 0x01, // A, IN
+					0x00, 0x00, 0x00, 0x3c, // TTL
+					0x00, 0x04, 127, 0, 0, 1,
+				)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// backendURI returns the test server URI using the given host
+func backendURI(host string) string {
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(TestServerPort)) + "/"
+}
+
+func TestResolve(t *testing.T) {
+	target := config.NewTarget(backendURI("backend.invalid"))
+	target.Resolve = "127.0.0.1"
+
+	info, err := ping("resolve", target, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Resolving != 0 {
+		t.Errorf("Expected no DNS request, got %v resolving", info.Resolving)
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	target := config.NewTarget(backendURI("backend.invalid"))
+	target.DNSServer = startDNSServer(t)
+
+	info, err := ping("dns", target, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Resolving <= 0 {
+		t.Error("Expected the resolving phase to be measured.")
+	}
+	if info.RemoteAddr != net.JoinHostPort("127.0.0.1", strconv.Itoa(TestServerPort)) {
+		t.Errorf("Expected the custom DNS server answer to be used, got %s", info.RemoteAddr)
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key
+// to dir and returns the certificate
+func writeClientCertificate(t *testing.T, dir string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDER)
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-timing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(writeClientCertificate(t, dir))
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", srv.Certificate().Raw)
+
+	target := config.NewTarget(srv.URL)
+	target.CAFile = filepath.Join(dir, "ca.pem")
+	if _, err := ping("mtls", target, "test"); err == nil {
+		t.Error("Expected the server to require a client certificate.")
+	}
+
+	target.ClientCert = filepath.Join(dir, "client.pem")
+	target.ClientKey = filepath.Join(dir, "client.key")
+	if _, err := ping("mtls", target, "test"); err != nil {
+		t.Error(err)
+	}
+
+	// The test server certificate is valid for example.com
+	target.ServerName = "example.com"
+	if _, err := ping("mtls", target, "test"); err != nil {
+		t.Error(err)
+	}
+	target.ServerName = "example.invalid"
+	if info, err := ping("mtls", target, "test"); err == nil || info.ErrorClass != "tls_error" {
+		t.Errorf("Expected a TLS error with the wrong server name, got %v", err)
+	}
+
+	// A renewed client certificate is used right away
+	target.ServerName = ""
+	transport, _ := getTransport(target)
+	cert := writeClientCertificate(t, dir)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "client.pem"), future, future)
+	if renewed, _ := getTransport(target); renewed == transport {
+		t.Error("Expected the transport to be created again for the renewed certificate.")
+	}
+	if _, err := ping("mtls", target, "test"); err == nil {
+		t.Error("Expected the server to reject the renewed certificate it does not trust.")
+	}
+	clientCAs.AddCert(cert)
+	if _, err := ping("mtls", target, "test"); err != nil {
+		t.Error(err)
+	}
+
+	target.CAFile = filepath.Join(dir, "missing.pem")
+	if info, err := ping("mtls", target, "test"); err == nil {
+		t.Error("Expected an error with a missing CA file.")
+	} else if info.Name != "mtls" || info.Start().IsZero() {
+		t.Errorf("Expected the failure to be reported under the target name, got %q", info.Name)
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	h2c := httptest.NewUnstartedServer(handler)
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetHTTP1(true)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+
+	for _, test := range []struct {
+		uri, protocol, proto, alpn string
+	}{
+		{srv.URL, "", "HTTP/2.0", "h2"},
+		{srv.URL, "http1", "HTTP/1.1", ""},
+		{srv.URL, "h2", "HTTP/2.0", "h2"},
+		{h2c.URL, "", "HTTP/1.1", ""},
+		{h2c.URL, "h2c", "HTTP/2.0", ""},
+	} {
+		target := config.NewTarget(test.uri)
+		target.InsecureSkipVerify = true
+		target.Protocol = test.protocol
+
+		info, err := ping("protocol", target, "test")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if info.Proto != test.proto || info.ALPN != test.alpn {
+			t.Errorf("%s over %s: expected %s with ALPN %q, got %s with %q", test.protocol, test.uri, test.proto, test.alpn, info.Proto, info.ALPN)
+		}
+	}
+}
+
+// startConnectProxy starts an HTTP proxy only supporting CONNECT, waiting for
+// the given delay before establishing the tunnel, it returns its URL
+func startConnectProxy(t *testing.T, delay time.Duration) string {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		time.Sleep(delay)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(upstream, buf)
+		io.Copy(conn, upstream)
+	}))
+	t.Cleanup(proxy.Close)
+
+	return proxy.URL
+}
+
+func TestProxy(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	target := config.NewTarget(srv.URL)
+	target.InsecureSkipVerify = true
+	target.Proxy = startConnectProxy(t, 20*time.Millisecond)
+
+	info, err := ping("proxy", target, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Proxy == "" || info.Connecting != 0 {
+		t.Errorf("Expected the connection to go through the proxy, got %s and %v connecting", info.Proxy, info.Connecting)
+	}
+	if info.ProxyConnect <= 0 || info.TunnelEstablished < 20*time.Millisecond {
+		t.Errorf("Expected the proxy phases to be timed, got %v and %v", info.ProxyConnect, info.TunnelEstablished)
+	}
+	if info.TLSHandshake <= 0 {
+		t.Error("Expected the TLS handshake with the target to be timed.")
+	}
+}
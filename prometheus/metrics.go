@@ -0,0 +1,193 @@
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+// Histogram buckets in seconds, same as the Prometheus client defaults
+var buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram holds cumulative observations following the Prometheus model
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// targetMetrics holds the metrics of a single target
+type targetMetrics struct {
+	uri        string
+	histograms map[string]*histogram
+	last       map[string]float64
+	statusCode float64
+	bodySize   float64
+	success    float64
+	probes     uint64
+	failures   uint64
+}
+
+// Metrics holds the metrics of all targets
+type Metrics struct {
+	lock    sync.RWMutex
+	targets map[string]*targetMetrics
+}
+
+// NewMetrics creates an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{targets: make(map[string]*targetMetrics)}
+}
+
+// Observe records the result of a ping
+func (m *Metrics) Observe(info *pinger.RequestInfo) {
+	info.Lock()
+	defer info.Unlock()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	t, ok := m.targets[info.Name]
+	if !ok {
+		t = &targetMetrics{
+			histograms: make(map[string]*histogram, len(pinger.AllPhaseNames)),
+			last:       make(map[string]float64, len(pinger.AllPhaseNames)),
+		}
+		for _, phase := range pinger.AllPhaseNames {
+			t.histograms[phase] = newHistogram()
+		}
+		m.targets[info.Name] = t
+	}
+
+	t.uri = info.URI
+	t.probes++
+	t.statusCode = float64(info.StatusCode)
+	t.bodySize = float64(info.BodySize)
+
+	if info.Error != nil {
+		t.failures++
+		t.success = 0
+	} else {
+		t.success = 1
+	}
+
+	for _, phase := range pinger.AllPhaseNames {
+		if !info.IsOk() {
+			t.last[phase] = math.NaN()
+			continue
+		}
+
+		v := toSeconds(info.Phase(phase))
+		t.last[phase] = v
+		t.histograms[phase].observe(v)
+	}
+}
+
+// Format writes the metrics in the Prometheus text exposition format
+func (m *Metrics) Format(buf io.Writer) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	names := make([]string, 0, len(m.targets))
+	for name := range m.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header(buf, "http_timing_duration_seconds", "histogram", "Duration of the HTTP request phases.")
+	for _, name := range names {
+		for _, phase := range pinger.AllPhaseNames {
+			h := m.targets[name].histograms[phase]
+			labels := fmt.Sprintf(`target="%s",phase="%s"`, escape(name), phase)
+			for i, bound := range buckets {
+				fmt.Fprintf(buf, "http_timing_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), h.counts[i])
+			}
+			fmt.Fprintf(buf, "http_timing_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+			fmt.Fprintf(buf, "http_timing_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+			fmt.Fprintf(buf, "http_timing_duration_seconds_count{%s} %d\n", labels, h.count)
+		}
+	}
+
+	header(buf, "http_timing_last_duration_seconds", "gauge", "Duration of the HTTP request phases during the last probe.")
+	for _, name := range names {
+		for _, phase := range pinger.AllPhaseNames {
+			fmt.Fprintf(buf, "http_timing_last_duration_seconds{target=\"%s\",phase=\"%s\"} %s\n", escape(name), phase, formatFloat(m.targets[name].last[phase]))
+		}
+	}
+
+	gauges := []struct {
+		name, help string
+		value      func(t *targetMetrics) float64
+	}{
+		{"http_timing_status_code", "HTTP status code of the last probe.", func(t *targetMetrics) float64 { return t.statusCode }},
+		{"http_timing_body_size_bytes", "Response body size of the last probe.", func(t *targetMetrics) float64 { return t.bodySize }},
+		{"http_timing_success", "Whether the last probe succeeded.", func(t *targetMetrics) float64 { return t.success }},
+	}
+	for _, g := range gauges {
+		header(buf, g.name, "gauge", g.help)
+		for _, name := range names {
+			fmt.Fprintf(buf, "%s{target=\"%s\"} %s\n", g.name, escape(name), formatFloat(g.value(m.targets[name])))
+		}
+	}
+
+	header(buf, "http_timing_probes_total", "counter", "Number of probes performed.")
+	for _, name := range names {
+		fmt.Fprintf(buf, "http_timing_probes_total{target=\"%s\"} %d\n", escape(name), m.targets[name].probes)
+	}
+
+	header(buf, "http_timing_probe_failures_total", "counter", "Number of probes that returned an error.")
+	for _, name := range names {
+		fmt.Fprintf(buf, "http_timing_probe_failures_total{target=\"%s\"} %d\n", escape(name), m.targets[name].failures)
+	}
+
+	header(buf, "http_timing_target_info", "gauge", "URI of the target.")
+	for _, name := range names {
+		fmt.Fprintf(buf, "http_timing_target_info{target=\"%s\",uri=\"%s\"} 1\n", escape(name), escape(m.targets[name].uri))
+	}
+}
+
+func header(buf io.Writer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+}
+
+// escape escapes a label value as required by the exposition format
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return fmt.Sprintf("%g", v)
+}
+
+func toSeconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Second)
+}
@@ -0,0 +1,83 @@
+package prometheus
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+func newRequestInfo(name string, statusCode int, total time.Duration) *pinger.RequestInfo {
+	info := pinger.NewRequestInfo()
+	info.Name = name
+	info.URI = "https://example.com/" + name
+	info.StatusCode = statusCode
+	info.BodySize = 42
+	info.Waiting = total / 2
+	info.Total = total
+
+	return info
+}
+
+func TestFormat(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Observe(newRequestInfo("example", 200, 250*time.Millisecond))
+	metrics.Observe(newRequestInfo("example", 200, 500*time.Millisecond))
+
+	buf := &bytes.Buffer{}
+	metrics.Format(buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE http_timing_duration_seconds histogram\n",
+		`http_timing_duration_seconds_bucket{target="example",phase="total",le="0.25"} 1` + "\n",
+		`http_timing_duration_seconds_bucket{target="example",phase="total",le="0.5"} 2` + "\n",
+		`http_timing_duration_seconds_bucket{target="example",phase="total",le="+Inf"} 2` + "\n",
+		`http_timing_duration_seconds_sum{target="example",phase="total"} 0.75` + "\n",
+		`http_timing_duration_seconds_count{target="example",phase="tls"} 2` + "\n",
+		`http_timing_last_duration_seconds{target="example",phase="waiting"} 0.25` + "\n",
+		`http_timing_status_code{target="example"} 200` + "\n",
+		`http_timing_body_size_bytes{target="example"} 42` + "\n",
+		`http_timing_success{target="example"} 1` + "\n",
+		`http_timing_probes_total{target="example"} 2` + "\n",
+		`http_timing_target_info{target="example",uri="https://example.com/example"} 1` + "\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestFormatFailure(t *testing.T) {
+	metrics := NewMetrics()
+	info := newRequestInfo("broken", 503, time.Second)
+	info.Error = errors.New("Got a 503")
+	metrics.Observe(info)
+
+	buf := &bytes.Buffer{}
+	metrics.Format(buf)
+	out := buf.String()
+
+	expected := []string{
+		`http_timing_duration_seconds_count{target="broken",phase="total"} 0` + "\n",
+		`http_timing_last_duration_seconds{target="broken",phase="total"} NaN` + "\n",
+		`http_timing_status_code{target="broken"} 503` + "\n",
+		`http_timing_success{target="broken"} 0` + "\n",
+		`http_timing_probe_failures_total{target="broken"} 1` + "\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if actual := escape("a\"b\\c\nd"); actual != `a\"b\\c\nd` {
+		t.Error("Label value not properly escaped, got ", actual)
+	}
+}
@@ -0,0 +1,59 @@
+package prometheus
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+var stderr = log.New(os.Stderr, "", 0)
+
+// Serve probes the targets every config.ProbeInterval and serves the
+// resulting metrics on config.ListenAddress under /metrics
+func Serve(config config.Config) error {
+	if len(config.Targets) <= 0 {
+		return errors.New("No URIs provided.")
+	}
+
+	metrics := NewMetrics()
+	go probe(config, metrics)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	return http.ListenAndServe(config.ListenAddress, mux)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	buf := &bytes.Buffer{}
+	m.Format(buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// probe pings all the targets forever, waiting config.ProbeInterval between
+// the start of each round
+func probe(config config.Config, metrics *Metrics) {
+	ticker := time.NewTicker(config.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, info := range pinger.PingAll(config) {
+			if info.Error != nil {
+				stderr.Print(info.Error)
+			}
+
+			metrics.Observe(info)
+		}
+
+		<-ticker.C
+	}
+}
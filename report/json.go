@@ -0,0 +1,35 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+var stderr = log.New(os.Stderr, "", 0)
+
+// DoJSON pings all the targets and returns the results as a JSON array
+func DoJSON(config config.Config) (string, error) {
+	if len(config.Targets) <= 0 {
+		return "", errors.New("No URIs provided.")
+	}
+
+	return formatJSON(pinger.PingAll(config))
+}
+
+// formatJSON returns the requests sorted by name as an indented JSON array
+func formatJSON(requests []*pinger.RequestInfo) (string, error) {
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Name < requests[j].Name })
+
+	out, err := json.MarshalIndent(requests, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out) + "\n", nil
+}
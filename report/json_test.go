@@ -0,0 +1,45 @@
+package report
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+func TestJSONWithoutURIs(t *testing.T) {
+	var config config.Config
+	if _, err := DoJSON(config); err == nil {
+		t.Error("DoJSON should fail when given no URIs.")
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	requests := make([]*pinger.RequestInfo, 0)
+	for _, name := range []string{"zzz", "aaa"} {
+		info := pinger.NewRequestInfo()
+		info.Name = name
+		info.StatusCode = 200
+		info.Total = 1500 * time.Microsecond
+		requests = append(requests, info)
+	}
+
+	out, err := formatJSON(requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != 2 || decoded[0]["name"] != "aaa" || decoded[1]["name"] != "zzz" {
+		t.Errorf("Expected requests sorted by name, got %v", decoded)
+	}
+	if total := decoded[0]["durations_us"].(map[string]interface{})["total"]; total != 1500.0 {
+		t.Error("Expected total in microseconds, got ", total)
+	}
+}
@@ -0,0 +1,105 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+const waterfallWidth = 50
+
+// DoProbe pings a single URI and returns a human-readable waterfall of the
+// request phases
+func DoProbe(conf config.Config, uri string) (string, error) {
+	parsed, err := url.ParseRequestURI(uri)
+	if err != nil || parsed.Host == "" {
+		return "", errors.New("Invalid URI: " + config.RedactURI(uri))
+	}
+
+	info := pinger.Ping("probe", config.NewTarget(uri), conf.UserAgent)
+	return formatWaterfall(info), nil
+}
+
+// formatWaterfall returns the request details followed by one bar per phase,
+// each bar starting where the previous phase ended
+func formatWaterfall(info *pinger.RequestInfo) string {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "URL:      %s\n", info.URI)
+	if remote := info.ConnectionSummary(); remote != "" {
+		fmt.Fprintf(buf, "Remote:   %s\n", remote)
+	}
+	if info.Proto != "" {
+		proto := info.Proto
+		if version := info.TLSVersionName(); version != "" && info.ALPN != "" {
+			proto += " (" + version + ", ALPN " + info.ALPN + ")"
+		} else if version != "" {
+			proto += " (" + version + ")"
+		}
+		fmt.Fprintf(buf, "Protocol: %s\n", proto)
+	}
+	if info.StatusCode != 0 {
+		fmt.Fprintf(buf, "Status:   %d, %d bytes\n", info.StatusCode, info.BodySize)
+	}
+	if info.Error != nil {
+		fmt.Fprintf(buf, "Error:    %s (%s)\n", strings.TrimSpace(info.Error.Error()), info.ErrorClass)
+	}
+	fmt.Fprintln(buf)
+
+	total := info.Phase("total")
+	var offset time.Duration
+	for _, name := range pinger.PhaseNames {
+		duration := info.Phase(name)
+		fmt.Fprintf(buf, "%-18s %s\n", name, formatBar(offset, duration, total))
+		offset += duration
+	}
+	fmt.Fprintf(buf, "%-18s %s\n", "total", formatBar(0, total, total))
+
+	return buf.String()
+}
+
+// formatBar returns the start offset and duration in milliseconds followed by
+// a bar scaled against the total duration
+func formatBar(offset, duration, total time.Duration) string {
+	start, width := 0, 0
+	if total > 0 {
+		start = int(int64(offset) * waterfallWidth / int64(total))
+		width = int(int64(duration) * waterfallWidth / int64(total))
+	}
+
+	// The phases may add up to more than the total, eg. when the first byte
+	// of the response is received before the request is fully written
+	if start < 0 {
+		start = 0
+	} else if start > waterfallWidth {
+		start = waterfallWidth
+	}
+	if width < 0 {
+		width = 0
+	} else if start+width > waterfallWidth {
+		width = waterfallWidth - start
+	}
+	if width == 0 && duration > 0 && start < waterfallWidth {
+		width = 1
+	}
+
+	return fmt.Sprintf(
+		"%8.1fms %8.1fms |%s%s%s|",
+		milliseconds(offset),
+		milliseconds(duration),
+		strings.Repeat(" ", start),
+		strings.Repeat("#", width),
+		strings.Repeat(" ", waterfallWidth-start-width),
+	)
+}
+
+// milliseconds returns a duration as fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
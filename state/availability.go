@@ -0,0 +1,152 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+var stderr = log.New(os.Stderr, "", 0)
+
+// Window is a rolling period over which the availability is computed
+type Window struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Windows lists the availability windows from the shortest to the longest,
+// checks older than the longest one are forgotten
+var Windows = []Window{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// Check is the outcome of a single request
+type Check struct {
+	Time int64 `json:"t"`
+	Ok   bool  `json:"ok"`
+}
+
+// History holds the checks of each target in chronological order
+type History struct {
+	Targets map[string][]Check `json:"targets"`
+}
+
+// historyPath returns the path of the state file, there is one per graph name
+// so multiple plugin instances can share the same state directory
+func historyPath(config config.Config) string {
+	return filepath.Join(config.StateDir, config.GetGraphName()+".availability.json")
+}
+
+// Update adds the outcome of the given requests to the history stored in the
+// state directory and returns it
+// Requests not newer than the latest check of their target are ignored so the
+// same daemon results are not counted twice.
+func Update(config config.Config, requests []*pinger.RequestInfo, now time.Time) (History, error) {
+	history := History{Targets: make(map[string][]Check)}
+
+	f, err := os.OpenFile(historyPath(config), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return history, fmt.Errorf("Unable to open the availability history: %s", err)
+	}
+	defer f.Close()
+
+	// Released when the file is closed
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return history, fmt.Errorf("Unable to lock the availability history: %s", err)
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return history, fmt.Errorf("Unable to read the availability history: %s", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &history); err != nil {
+			stderr.Printf("Ignoring invalid availability history: %s\n", err)
+		}
+		if history.Targets == nil {
+			history.Targets = make(map[string][]Check)
+		}
+	}
+
+	history.add(requests, now)
+	history.prune(config, now)
+
+	if data, err = json.Marshal(history); err != nil {
+		return history, err
+	}
+	if err := f.Truncate(0); err != nil {
+		return history, err
+	}
+	_, err = f.WriteAt(data, 0)
+
+	return history, err
+}
+
+// add appends the outcome of the requests newer than the latest check of
+// their target
+func (h History) add(requests []*pinger.RequestInfo, now time.Time) {
+	for _, info := range requests {
+		at := info.Start()
+		if at.IsZero() {
+			at = now
+		}
+
+		checks := h.Targets[info.Name]
+		if len(checks) > 0 && at.Unix() <= checks[len(checks)-1].Time {
+			continue
+		}
+
+		h.Targets[info.Name] = append(checks, Check{Time: at.Unix(), Ok: info.Error == nil})
+	}
+}
+
+// prune forgets the targets no longer configured and the checks older than
+// the longest window
+func (h History) prune(config config.Config, now time.Time) {
+	oldest := now.Add(-Windows[len(Windows)-1].Duration).Unix()
+	for name, checks := range h.Targets {
+		if _, ok := config.Targets[name]; !ok {
+			delete(h.Targets, name)
+			continue
+		}
+
+		i := 0
+		for i < len(checks) && checks[i].Time < oldest {
+			i++
+		}
+		h.Targets[name] = checks[i:]
+	}
+}
+
+// Availability returns the percentage of successful checks of the target over
+// the given window, ok is false if there are none
+func (h History) Availability(name string, window time.Duration, now time.Time) (percentage float64, ok bool) {
+	since := now.Add(-window).Unix()
+	total, succeeded := 0, 0
+	for _, check := range h.Targets[name] {
+		if check.Time < since {
+			continue
+		}
+
+		total++
+		if check.Ok {
+			succeeded++
+		}
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	return 100 * float64(succeeded) / float64(total), true
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DigitalBackstage/munin-http-timing/config"
+	"github.com/DigitalBackstage/munin-http-timing/pinger"
+)
+
+func newTestConfig(t *testing.T) (config.Config, func()) {
+	dir, err := ioutil.TempDir("", "http-timing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := config.Config{
+		Targets: map[string]config.Target{
+			"example": config.NewTarget("https://example.com/"),
+		},
+		StateDir: dir,
+	}
+
+	return config, func() { os.RemoveAll(dir) }
+}
+
+func newRequestInfo(name string, failed bool) *pinger.RequestInfo {
+	info := pinger.NewRequestInfo()
+	info.RequestStart(name, "https://example.com/")
+	if failed {
+		info.Error = errors.New("Got a 500, unable to fetch https://example.com/")
+	}
+
+	return info
+}
+
+func TestUpdate(t *testing.T) {
+	config, remove := newTestConfig(t)
+	defer remove()
+
+	ok := newRequestInfo("example", false)
+	now := ok.Start()
+
+	// Checks are stored with a one second resolution
+	time.Sleep(time.Until(now.Truncate(time.Second).Add(time.Second)))
+	failed := newRequestInfo("example", true)
+
+	if _, err := Update(config, []*pinger.RequestInfo{ok, newRequestInfo("removed", false)}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not newer than the previous check, ignored
+	history, err := Update(config, []*pinger.RequestInfo{ok}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Targets["example"]) != 1 || len(history.Targets["removed"]) != 0 {
+		t.Errorf("Expected a single check of the configured target, got %v", history.Targets)
+	}
+
+	if !history.Targets["example"][0].Ok {
+		t.Error("Expected a successful check.")
+	}
+
+	history, err = Update(config, []*pinger.RequestInfo{failed}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checks := history.Targets["example"]; len(checks) != 2 || checks[1].Ok {
+		t.Errorf("Expected a failed check to be added, got %v", checks)
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	now := time.Now()
+	history := History{Targets: map[string][]Check{
+		"example": {
+			{Time: now.Add(-2 * time.Hour).Unix(), Ok: true},
+			{Time: now.Add(-time.Minute).Unix(), Ok: false},
+		},
+	}}
+
+	if percentage, ok := history.Availability("example", time.Hour, now); !ok || percentage != 0 {
+		t.Errorf("Expected 0%% over the last hour, got %v", percentage)
+	}
+	if percentage, ok := history.Availability("example", 24*time.Hour, now); !ok || percentage != 50 {
+		t.Errorf("Expected 50%% over the last day, got %v", percentage)
+	}
+	if _, ok := history.Availability("missing", time.Hour, now); ok {
+		t.Error("Expected no availability without checks.")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	config, remove := newTestConfig(t)
+	defer remove()
+
+	now := time.Now()
+	history := History{Targets: map[string][]Check{
+		"example": {
+			{Time: now.Add(-8 * 24 * time.Hour).Unix(), Ok: true},
+			{Time: now.Add(-time.Minute).Unix(), Ok: false},
+		},
+		"removed": {{Time: now.Unix(), Ok: true}},
+	}}
+	history.prune(config, now)
+
+	if len(history.Targets) != 1 || len(history.Targets["example"]) != 1 || history.Targets["example"][0].Ok {
+		t.Errorf("Expected only the recent check of the configured target, got %v", history.Targets)
+	}
+}